@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"sync"
 	"time"
 
+	pb "github.com/iamazeem/cwm-keda-external-scaler/externalscaler"
 	log "github.com/sirupsen/logrus"
 
 	"google.golang.org/grpc/codes"
@@ -10,7 +13,7 @@ import (
 )
 
 var (
-	cache metricCache
+	cache = newMetricCache()
 )
 
 type metricData struct {
@@ -18,88 +21,205 @@ type metricData struct {
 	metric    metric
 }
 
+// metricCacheKey identifies a series of metric values of a ScaledObject.
+// Two ScaledObjects sharing a deploymentid, or the same ScaledObject with a
+// different scaleMetricName, never share the same series.
+type metricCacheKey struct {
+	namespace    string
+	name         string
+	deploymentid string
+	metricName   string
+}
+
+func newMetricCacheKey(scaledObjectRef *pb.ScaledObjectRef, metricName string) metricCacheKey {
+	return metricCacheKey{
+		namespace:    scaledObjectRef.Namespace,
+		name:         scaledObjectRef.Name,
+		deploymentid: getValueFromScalerMetadata(scaledObjectRef.ScalerMetadata, keyDeploymentId, defaultDeploymentId),
+		metricName:   metricName,
+	}
+}
+
+func (k metricCacheKey) String() string {
+	return fmt.Sprintf("%v/%v deploymentid: %v, metric: %v", k.namespace, k.name, k.deploymentid, k.metricName)
+}
+
+// metricSlot holds the metric values of a single series.
+// A slot is locked individually so that the concurrent gRPC calls for
+// different series do not block each other.
+type metricSlot struct {
+	mutex   sync.Mutex
+	data    []metricData
+	removed bool // set once the slot has been purged from the cache
+}
+
 type metricCache struct {
-	cache map[string][]metricData // map: deploymentid => metricData
+	mutex sync.RWMutex
+	cache map[metricCacheKey]*metricSlot // map: {namespace, name, deploymentid, metric} => metricSlot
+}
+
+func newMetricCache() *metricCache {
+	log.Debug("cache initialized")
+	return &metricCache{
+		cache: make(map[metricCacheKey]*metricSlot),
+	}
+}
+
+func (c *metricCache) getSlot(key metricCacheKey) (*metricSlot, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	slot, exists := c.cache[key]
+	return slot, exists
+}
+
+func (c *metricCache) getOrCreateSlot(key metricCacheKey) *metricSlot {
+	if slot, exists := c.getSlot(key); exists {
+		return slot
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	slot, exists := c.cache[key]
+	if !exists {
+		slot = &metricSlot{}
+		c.cache[key] = slot
+		log.Debugf("[%v] cache slot created", key)
+	}
+
+	return slot
 }
 
-func (c *metricCache) initializeIfNil() {
-	if c.cache == nil {
-		c.cache = make(map[string][]metricData)
-		log.Debug("cache initialized")
+func (c *metricCache) remove(key metricCacheKey) {
+	slot, exists := c.getSlot(key)
+	if !exists {
+		return
 	}
+
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+
+	c.removeSlot(key, slot)
+	log.Infof("[%v] cache slot removed", key)
 }
 
-func (c *metricCache) getSize(deploymentid string) int {
-	return len(c.cache[deploymentid])
+// removeSlot must be called with the slot's mutex held
+func (c *metricCache) removeSlot(key metricCacheKey, slot *metricSlot) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cache[key] == slot {
+		delete(c.cache, key)
+	}
+
+	slot.removed = true
 }
 
-func (c *metricCache) isEmpty(deploymentid string) bool {
-	return c.getSize(deploymentid) == 0
+func (c *metricCache) getSize(key metricCacheKey) int {
+	slot, exists := c.getSlot(key)
+	if !exists {
+		return 0
+	}
+
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+
+	return len(slot.data)
 }
 
-func (c *metricCache) append(deploymentid string, metric metric, scalePeriodSeconds int64) {
-	c.initializeIfNil()
+func (c *metricCache) isEmpty(key metricCacheKey) bool {
+	return c.getSize(key) == 0
+}
 
-	log.Debugf("[deploymentid: %v] appending metric {name: %v, value: %v}", deploymentid, metric.name, metric.value)
+func (c *metricCache) append(key metricCacheKey, metric metric, scalePeriodSeconds int64) {
+	log.Debugf("[%v] appending metric {name: %v, value: %v}", key, metric.name, metric.value)
 
-	c.cache[deploymentid] = append(c.cache[deploymentid], metricData{
-		timestamp: time.Now().UTC(),
-		metric:    metric,
-	})
+	for {
+		slot := c.getOrCreateSlot(key)
+		slot.mutex.Lock()
 
-	log.Debugf("[deploymentid: %v] appended metric {name: %v, value: %v}", deploymentid, metric.name, metric.value)
+		// the slot was purged between the lookup and the lock, retry with a new one
+		if slot.removed {
+			slot.mutex.Unlock()
+			continue
+		}
+
+		slot.data = append(slot.data, metricData{
+			timestamp: time.Now().UTC(),
+			metric:    metric,
+		})
+
+		log.Debugf("[%v] appended metric {name: %v, value: %v}", key, metric.name, metric.value)
 
-	c.purge(deploymentid, scalePeriodSeconds)
+		c.purge(key, slot, scalePeriodSeconds)
+
+		slot.mutex.Unlock()
+		return
+	}
 }
 
-func (c *metricCache) getPurgeIndex(deploymentid string, scalePeriodSeconds int64) int64 {
+func getPurgeIndex(key metricCacheKey, data []metricData, scalePeriodSeconds int64) int64 {
 	var index int64 = 0
 
 	now := time.Now().UTC()
-	for _, d := range c.cache[deploymentid] {
+	for _, d := range data {
 		seconds := now.Sub(d.timestamp).Seconds()
 		if seconds > float64(scalePeriodSeconds) {
 			index++
 		}
 	}
 
-	log.Debugf("[deploymentid: %v] number of values to purge: %v", deploymentid, index)
+	log.Debugf("[%v] number of values to purge: %v", key, index)
 
 	return index
 }
 
-func (c *metricCache) purge(deploymentid string, scalePeriodSeconds int64) {
-	log.Debugf("[deploymentid: %v] purging metric values [%v = %v]", deploymentid, keyScalePeriodSeconds, scalePeriodSeconds)
+// purge must be called with the slot's mutex held
+func (c *metricCache) purge(key metricCacheKey, slot *metricSlot, scalePeriodSeconds int64) {
+	log.Debugf("[%v] purging metric values [%v = %v]", key, keyScalePeriodSeconds, scalePeriodSeconds)
 
-	if c.isEmpty(deploymentid) {
-		log.Debugf("[deploymentid: %v] cache is already empty, purge not needed", deploymentid)
+	if len(slot.data) == 0 {
+		log.Debugf("[%v] cache is already empty, purge not needed", key)
 		return
 	}
 
 	// remove values with timestamps with difference older than scalePeriodSeconds
 	// e.g. if scalePeriodSeconds = 600, all the values with difference >= 600 will be removed
-	purgeIndex := c.getPurgeIndex(deploymentid, scalePeriodSeconds)
+	purgeIndex := getPurgeIndex(key, slot.data, scalePeriodSeconds)
 	if purgeIndex > 0 {
-		oldCacheSize := c.getSize(deploymentid)
-		c.cache[deploymentid] = c.cache[deploymentid][purgeIndex:]
-		newCacheSize := c.getSize(deploymentid)
+		oldCacheSize := len(slot.data)
+		slot.data = slot.data[purgeIndex:]
+		newCacheSize := len(slot.data)
 		noOfValuesPurged := oldCacheSize - newCacheSize
-		log.Infof("[deploymentid: %v] purged %v value(s). cache size: {old: %v, new: %v}", deploymentid, noOfValuesPurged, oldCacheSize, newCacheSize)
+		log.Infof("[%v] purged %v value(s). cache size: {old: %v, new: %v}", key, noOfValuesPurged, oldCacheSize, newCacheSize)
 	}
 
-	// after purging values, if a cache's list for a certain deploymentid is empty,
+	// after purging values, if a cache's list for a certain series is empty,
 	// it's best to purge its slot completely also instead of retaining its memory,
-	// for the same deploymentid, the slot will be added again if it reappears later
-	if c.isEmpty(deploymentid) {
-		delete(c.cache, deploymentid)
-		log.Infof("[deploymentid: %v] empty cache slot purged completely", deploymentid)
+	// for the same series, the slot will be added again if it reappears later
+	if len(slot.data) == 0 {
+		c.removeSlot(key, slot)
+		log.Infof("[%v] empty cache slot purged completely", key)
 	}
 }
 
-func (c *metricCache) getOldestMetricData(deploymentid string) (metricData, error) {
-	if c.isEmpty(deploymentid) {
-		return metricData{}, status.Errorf(codes.NotFound, "[deploymentid: %v] cache is empty", deploymentid)
+// getMetricData returns a copy of the metric values of a series, oldest first
+func (c *metricCache) getMetricData(key metricCacheKey) ([]metricData, error) {
+	slot, exists := c.getSlot(key)
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "[%v] cache is empty", key)
 	}
 
-	return c.cache[deploymentid][0], nil
+	slot.mutex.Lock()
+	defer slot.mutex.Unlock()
+
+	if len(slot.data) == 0 {
+		return nil, status.Errorf(codes.NotFound, "[%v] cache is empty", key)
+	}
+
+	data := make([]metricData, len(slot.data))
+	copy(data, slot.data)
+
+	return data, nil
 }
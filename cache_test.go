@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/iamazeem/cwm-keda-external-scaler/externalscaler"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestCacheKey(name string) metricCacheKey {
+	return metricCacheKey{
+		namespace:    "cache-test",
+		name:         name,
+		deploymentid: name,
+		metricName:   keyScaleMetricBytesOut,
+	}
+}
+
+func TestCacheAppendAndPurge(t *testing.T) {
+	c := newMetricCache()
+	key := newTestCacheKey("purge")
+
+	c.append(key, metric{keyScaleMetricBytesOut, 1}, 60)
+	c.append(key, metric{keyScaleMetricBytesOut, 2}, 60)
+	if size := c.getSize(key); size != 2 {
+		t.Fatalf("got size %v, want 2", size)
+	}
+
+	// age the first value beyond scalePeriodSeconds
+	slot, _ := c.getSlot(key)
+	slot.mutex.Lock()
+	slot.data[0].timestamp = time.Now().UTC().Add(-time.Hour)
+	slot.mutex.Unlock()
+
+	c.append(key, metric{keyScaleMetricBytesOut, 3}, 60)
+	data, err := c.getMetricData(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 2 || data[0].metric.value != 2 || data[1].metric.value != 3 {
+		t.Errorf("got %v, want the values 2 and 3", data)
+	}
+
+	// a copy is returned
+	data[0].metric.value = 100
+	if data, _ := c.getMetricData(key); data[0].metric.value != 2 {
+		t.Errorf("got %v, the cached values must not be modified", data[0].metric.value)
+	}
+
+	// an empty slot is purged completely
+	slot.mutex.Lock()
+	for i := range slot.data {
+		slot.data[i].timestamp = time.Now().UTC().Add(-time.Hour)
+	}
+	c.purge(key, slot, 60)
+	removed := slot.removed
+	slot.mutex.Unlock()
+
+	if !removed {
+		t.Error("empty slot not marked as removed")
+	}
+	if _, err := c.getMetricData(key); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestCacheRemove(t *testing.T) {
+	c := newMetricCache()
+	key := newTestCacheKey("remove")
+	other := newTestCacheKey("remove-other")
+
+	c.append(key, metric{keyScaleMetricBytesOut, 1}, 60)
+	c.append(other, metric{keyScaleMetricBytesOut, 1}, 60)
+	c.remove(key)
+	c.remove(newTestCacheKey("remove-missing"))
+
+	if !c.isEmpty(key) {
+		t.Error("removed series is not empty")
+	}
+	if c.isEmpty(other) {
+		t.Error("other series must not be removed")
+	}
+}
+
+// TestCacheAppendRetriesRemovedSlot removes a slot while an append is waiting
+// for its lock, the append must retry with a new slot
+func TestCacheAppendRetriesRemovedSlot(t *testing.T) {
+	c := newMetricCache()
+	key := newTestCacheKey("retry")
+
+	oldSlot := c.getOrCreateSlot(key)
+	oldSlot.mutex.Lock()
+
+	done := make(chan struct{})
+	go func() {
+		c.append(key, metric{keyScaleMetricBytesOut, 1}, 60)
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	c.removeSlot(key, oldSlot)
+	oldSlot.mutex.Unlock()
+	<-done
+
+	newSlot, exists := c.getSlot(key)
+	if !exists || newSlot == oldSlot {
+		t.Fatal("append did not retry with a new slot")
+	}
+	if len(oldSlot.data) != 0 {
+		t.Errorf("got %v values in the removed slot, want none", len(oldSlot.data))
+	}
+	if data, err := c.getMetricData(key); err != nil || len(data) != 1 {
+		t.Errorf("got %v [%v], want one value", data, err)
+	}
+}
+
+// TestParallelIsActiveAndGetMetrics runs concurrent IsActive/GetMetrics calls
+// for ScaledObjects sharing and not sharing their series, along with the
+// sampler and the removal of series. It is meant to be run with -race.
+func TestParallelIsActiveAndGetMetrics(t *testing.T) {
+	const deployments = 4
+	const callers = 8
+	const calls = 20
+
+	refs := []*pb.ScaledObjectRef{}
+	for i := 0; i < deployments; i++ {
+		deploymentid := fmt.Sprintf("cache-test-parallel-%v", i)
+		memory.set(deploymentid, map[string]int64{
+			keyScaleMetricBytesIn:  0,
+			keyScaleMetricBytesOut: 0,
+		}, time.Now().UTC())
+
+		// two ScaledObjects per deployment with distinct series
+		for _, name := range []string{"a", "b"} {
+			refs = append(refs, &pb.ScaledObjectRef{
+				Name:      fmt.Sprintf("%v-%v", deploymentid, name),
+				Namespace: "cache-test",
+				ScalerMetadata: map[string]string{
+					keySource:       sourceMemory,
+					keyDeploymentId: deploymentid,
+					keyScaleMetrics: "bytes_in:10,bytes_out:10",
+				},
+			})
+		}
+	}
+
+	server := &externalScalerServer{}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(refs)*callers*calls*3)
+
+	// several callers share each series
+	for _, ref := range refs {
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func(ref *pb.ScaledObjectRef, i int) {
+				defer wg.Done()
+				deploymentid := ref.ScalerMetadata[keyDeploymentId]
+				for j := 0; j < calls; j++ {
+					memory.add(deploymentid, keyScaleMetricBytesIn, int64(j))
+					memory.add(deploymentid, keyScaleMetricBytesOut, int64(i))
+
+					if _, err := server.IsActive(ctx, ref); err != nil {
+						errs <- err
+					}
+
+					for _, metricName := range []string{keyScaleMetricBytesIn, keyScaleMetricBytesOut} {
+						response, err := server.GetMetrics(ctx, &pb.GetMetricsRequest{ScaledObjectRef: ref, MetricName: metricName})
+						if err != nil {
+							errs <- err
+						} else if value := response.MetricValues[0].MetricValue; value < 0 {
+							errs <- fmt.Errorf("%v: negative value %v", metricName, value)
+						}
+					}
+
+					// drop the series now and then while they are in use
+					if j%7 == 0 {
+						cache.remove(newMetricCacheKey(ref, keyScaleMetricBytesIn))
+					}
+				}
+			}(ref, i)
+		}
+	}
+
+	stop := make(chan struct{})
+	samplerDone := make(chan struct{})
+	go func() {
+		defer close(samplerDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				sampler.sampleAll(time.Hour)
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	<-samplerDone
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	for _, ref := range refs {
+		for _, metricName := range []string{keyScaleMetricBytesIn, keyScaleMetricBytesOut} {
+			cache.remove(newMetricCacheKey(ref, metricName))
+		}
+	}
+}
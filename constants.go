@@ -11,6 +11,55 @@ const (
 	keyLastUpdatePrefix = "LAST_UPDATE_PREFIX"
 	keyMetricsPrefix    = "METRICS_PREFIX"
 
+	keyRedisUsername              = "CWM_REDIS_USERNAME"
+	keyRedisPassword              = "CWM_REDIS_PASSWORD"
+	keyRedisPasswordFile          = "CWM_REDIS_PASSWORD_FILE"
+	keyRedisTls                   = "CWM_REDIS_TLS"
+	keyRedisTlsCaFile             = "CWM_REDIS_TLS_CA_FILE"
+	keyRedisTlsCertFile           = "CWM_REDIS_TLS_CERT_FILE"
+	keyRedisTlsKeyFile            = "CWM_REDIS_TLS_KEY_FILE"
+	keyRedisTlsServerName         = "CWM_REDIS_TLS_SERVER_NAME"
+	keyRedisTlsInsecureSkipVerify = "CWM_REDIS_TLS_INSECURE_SKIP_VERIFY"
+
+	keyRedisMode             = "CWM_REDIS_MODE"
+	keyRedisMasterName       = "CWM_REDIS_MASTER_NAME"
+	keyRedisSentinelAddrs    = "CWM_REDIS_SENTINEL_ADDRS"
+	keyRedisSentinelPassword = "CWM_REDIS_SENTINEL_PASSWORD"
+	keyRedisClusterAddrs     = "CWM_REDIS_CLUSTER_ADDRS"
+
+	keyRedisDialTimeoutSeconds         = "CWM_REDIS_DIAL_TIMEOUT_SECONDS"
+	keyRedisReadTimeoutSeconds         = "CWM_REDIS_READ_TIMEOUT_SECONDS"
+	keyRedisWriteTimeoutSeconds        = "CWM_REDIS_WRITE_TIMEOUT_SECONDS"
+	keyRedisPoolSize                   = "CWM_REDIS_POOL_SIZE"
+	keyRedisHealthCheckIntervalSeconds = "CWM_REDIS_HEALTH_CHECK_INTERVAL_SECONDS"
+	keyRedisBackoffMinSeconds          = "CWM_REDIS_BACKOFF_MIN_SECONDS"
+	keyRedisBackoffMaxSeconds          = "CWM_REDIS_BACKOFF_MAX_SECONDS"
+
+	keyLastUpdateKeyTemplate = "LAST_UPDATE_KEY_TEMPLATE"
+	keyMetricsKeyTemplate    = "METRICS_KEY_TEMPLATE"
+
+	keyMetricsStorage         = "METRICS_STORAGE"
+	keyMetricsHashKeyTemplate = "METRICS_HASH_KEY_TEMPLATE"
+	keyLastUpdateHashField    = "LAST_UPDATE_HASH_FIELD"
+
+	keyMetricsSource = "METRICS_SOURCE"
+
+	keyPrometheusAddress        = "PROMETHEUS_ADDRESS"
+	keyPrometheusTimeoutSeconds = "PROMETHEUS_TIMEOUT_SECONDS"
+
+	keyWebhookAddress   = "WEBHOOK_ADDRESS"
+	keyWebhookAuthToken = "WEBHOOK_AUTH_TOKEN"
+
+	keyWebhookMaxDeployments     = "WEBHOOK_MAX_DEPLOYMENTS"
+	keyWebhookIdleTimeoutSeconds = "WEBHOOK_IDLE_TIMEOUT_SECONDS"
+
+	keySampleIntervalSeconds    = "SAMPLE_INTERVAL_SECONDS"
+	keySampleIdleTimeoutSeconds = "SAMPLE_IDLE_TIMEOUT_SECONDS"
+
+	keyForecastBucketSeconds  = "FORECAST_BUCKET_SECONDS"
+	keyForecastSeasonSeconds  = "FORECAST_SEASON_SECONDS"
+	keyForecastHistorySeconds = "FORECAST_HISTORY_SECONDS"
+
 	// default values
 	defaultLogLevel         = "info"
 	defaultRedisHost        = "localhost"
@@ -18,6 +67,114 @@ const (
 	defaultRedisDb          = "0"
 	defaultLastUpdatePrefix = "deploymentid:last_action"
 	defaultMetricsPrefix    = "deploymentid:minio-metrics"
+
+	defaultRedisUsername              = ""
+	defaultRedisPassword              = ""
+	defaultRedisPasswordFile          = ""
+	defaultRedisTls                   = "false"
+	defaultRedisTlsCaFile             = ""
+	defaultRedisTlsCertFile           = ""
+	defaultRedisTlsKeyFile            = ""
+	defaultRedisTlsServerName         = ""
+	defaultRedisTlsInsecureSkipVerify = "false"
+
+	defaultRedisMode             = redisModeStandalone
+	defaultRedisMasterName       = "mymaster"
+	defaultRedisSentinelAddrs    = "localhost:26379"
+	defaultRedisSentinelPassword = ""
+	defaultRedisClusterAddrs     = "localhost:6379"
+
+	defaultRedisDialTimeoutSeconds         = "5"
+	defaultRedisReadTimeoutSeconds         = "3"
+	defaultRedisWriteTimeoutSeconds        = "3"
+	defaultRedisPoolSize                   = "0" // go-redis default
+	defaultRedisHealthCheckIntervalSeconds = "10"
+	defaultRedisBackoffMinSeconds          = "1"
+	defaultRedisBackoffMaxSeconds          = "60"
+
+	defaultLastUpdateKeyTemplate = keyTemplatePrefix + ":" + keyTemplateDeploymentId
+	defaultMetricsKeyTemplate    = keyTemplatePrefix + ":" + keyTemplateMetric
+
+	defaultMetricsStorage         = metricsStorageString
+	defaultMetricsHashKeyTemplate = keyTemplatePrefix + ":" + keyTemplateDeploymentId
+	defaultLastUpdateHashField    = "last_action"
+
+	defaultMetricsSource = sourceRedis
+
+	defaultPrometheusAddress        = "http://localhost:9090"
+	defaultPrometheusTimeoutSeconds = "5"
+
+	defaultWebhookAddress   = "" // disabled
+	defaultWebhookAuthToken = ""
+
+	defaultWebhookMaxDeployments     = "10000"
+	defaultWebhookIdleTimeoutSeconds = "3600"
+
+	defaultSampleIntervalSeconds    = "10"
+	defaultSampleIdleTimeoutSeconds = "3600"
+
+	defaultForecastBucketSeconds  = "300"
+	defaultForecastSeasonSeconds  = "86400"  // daily seasonality
+	defaultForecastHistorySeconds = "604800" // 7 days
+)
+
+// Metric sources
+
+const (
+	sourceRedis      = "redis"
+	sourcePrometheus = "prometheus"
+	sourceWebhook    = "webhook"
+	sourceHttp       = "http"
+)
+
+// Audit webhook
+
+const (
+	webhookPath         = "/minio/audit/"
+	webhookMaxBodyBytes = 10 << 20 // 10 MiB
+)
+
+// HTTP and Prometheus sources
+
+const (
+	httpMaxResponseBytes = 10 << 20 // 10 MiB
+)
+
+// Redis modes
+
+const (
+	redisModeStandalone = "standalone"
+	redisModeSentinel   = "sentinel"
+	redisModeCluster    = "cluster"
+)
+
+// Redis connection states
+
+const (
+	redisStateDisconnected = "disconnected"
+	redisStateConnected    = "connected"
+	redisStateBackoff      = "backoff"
+)
+
+// Health services
+
+const (
+	redisHealthService = "redis"
+)
+
+// Metrics storage layouts
+
+const (
+	metricsStorageString = "string" // one string key per metric
+	metricsStorageHash   = "hash"   // one hash key per deployment with a field per metric
+)
+
+// Redis key template placeholders
+
+const (
+	keyTemplatePrefix       = "{prefix}"
+	keyTemplateDeploymentId = "{deploymentid}"
+	keyTemplateMetric       = "{metric}"
 )
 
 // Local configuration (ScaledObject metadata)
@@ -27,15 +184,57 @@ const (
 	keyDeploymentId       = "deploymentid"
 	keyIsActiveTtlSeconds = "isActiveTtlSeconds"
 	keyScaleMetricName    = "scaleMetricName"
+	keyScaleMetrics       = "scaleMetrics"
 	keyScalePeriodSeconds = "scalePeriodSeconds"
 	keyTargetValue        = "targetValue"
 
+	keyStreamIsActiveIntervalSeconds = "streamIsActiveIntervalSeconds"
+	keyScaleMetricExpression         = "scaleMetricExpression"
+	keyMetricAggregation             = "metricAggregation"
+	keyWindowFunction                = "windowFunction"
+	keySmoothing                     = "smoothing"
+	keySmoothingHalfLifeSeconds      = "smoothingHalfLifeSeconds"
+	keySmoothingTrendHalfLifeSeconds = "smoothingTrendHalfLifeSeconds"
+	keyForecastHorizonSeconds        = "forecastHorizonSeconds"
+	keyOnRedisError                  = "onRedisError"
+	keyMaxStalenessSeconds           = "maxStalenessSeconds"
+	keySource                        = "source"
+
+	keyPrometheusServerAddress     = "prometheusAddress"
+	keyPrometheusQuery             = "prometheusQuery"
+	keyPrometheusLastActivityQuery = "prometheusLastActivityQuery"
+
+	keyHttpUrl                  = "url"
+	keyHttpValueSelector        = "valueSelector"
+	keyHttpLastActivitySelector = "lastActivitySelector"
+	keyHttpTimeoutSeconds       = "timeoutSeconds"
+	keyHttpHeaders              = "headers"
+
+	keyMetricType      = "metricType"
+	keyTrimEventWindow = "trimEventWindow"
+	keyConsumerGroup   = "consumerGroup"
+
 	// default values
 	defaultDeploymentId       = "minio"
 	defaultIsActiveTtlSeconds = "600"
 	defaultScaleMetricName    = "bytes_out"
 	defaultScalePeriodSeconds = "600"
 	defaultTargetValue        = "10"
+
+	defaultStreamIsActiveIntervalSeconds = "5"
+	defaultMetricAggregation             = metricAggregationDelta
+	defaultWindowFunction                = windowFunctionDelta
+	defaultSmoothing                     = smoothingNone
+	defaultSmoothingHalfLifeSeconds      = "60"
+	defaultSmoothingTrendHalfLifeSeconds = "300"
+	defaultForecastHorizonSeconds        = "0" // disabled
+	defaultOnRedisError                  = onRedisErrorError
+	defaultMaxStalenessSeconds           = "300"
+
+	defaultHttpTimeoutSeconds = "5"
+
+	defaultMetricType      = metricTypeCounter
+	defaultTrimEventWindow = "false"
 )
 
 // Scale Metric Names
@@ -52,3 +251,51 @@ const (
 	keyScaleMetricNumRequestsInOut = "num_requests_in_out"
 	keyScaleMetricNumRequestsTotal = "num_requests_total"
 )
+
+// Metric Aggregations
+
+const (
+	metricAggregationDelta         = "delta"
+	metricAggregationRate          = "rate"
+	metricAggregationRatePerMinute = "ratePerMinute"
+)
+
+// Window Functions
+
+const (
+	windowFunctionDelta   = "delta"
+	windowFunctionAvgRate = "avg_rate"
+	windowFunctionMaxRate = "max_rate"
+	windowFunctionP95Rate = "p95_rate"
+	windowFunctionMinRate = "min_rate"
+)
+
+// Metric Types
+
+const (
+	metricTypeCounter = "counter" // ever-growing counter, aggregated by the scaler
+	metricTypeZCount  = "zcount"  // number of events of a sorted set within scalePeriodSeconds
+	metricTypeZSum    = "zsum"    // sum of the event values of a sorted set within scalePeriodSeconds
+
+	// queues
+	metricTypeLLen     = "llen"     // length of a list
+	metricTypeXLen     = "xlen"     // length of a stream
+	metricTypeXPending = "xpending" // entries of a stream not acknowledged by a consumer group: lag plus pending
+)
+
+// Smoothing
+
+const (
+	smoothingNone = "none"
+	smoothingEwma = "ewma"
+	smoothingHolt = "holt"
+)
+
+// Fallback policies on Redis errors
+
+const (
+	onRedisErrorError     = "error"
+	onRedisErrorLastKnown = "lastKnown"
+	onRedisErrorZero      = "zero"
+	onRedisErrorHold      = "hold"
+)
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A small expression language over the metrics stored in Redis e.g.
+//
+//   bytes_out + 4096 * num_requests_out
+//   max(bytes_in, bytes_out)
+//
+// It supports int64 literals, metric names, the binary operators + - * /,
+// unary -, parentheses and the functions min() and max() with one or more
+// arguments. The metric names are resolved from Redis on evaluation.
+
+var (
+	expressions      = map[string]*metricExpression{} // map: expression => parsed expression
+	expressionsMutex sync.Mutex
+)
+
+type expressionNode interface {
+	evaluate(values map[string]int64) (int64, error)
+}
+
+type numberNode struct {
+	value int64
+}
+
+type metricNode struct {
+	name string
+}
+
+type unaryNode struct {
+	operand expressionNode
+}
+
+type binaryNode struct {
+	operator    rune
+	left, right expressionNode
+}
+
+type functionNode struct {
+	name      string
+	arguments []expressionNode
+}
+
+type metricExpression struct {
+	source      string
+	root        expressionNode
+	metricNames []string // referenced metric names, in order of first appearance
+}
+
+func (n numberNode) evaluate(_ map[string]int64) (int64, error) {
+	return n.value, nil
+}
+
+func (n metricNode) evaluate(values map[string]int64) (int64, error) {
+	if value, exists := values[n.name]; exists {
+		return value, nil
+	}
+
+	return -1, status.Errorf(codes.Internal, "no value for metric: %v", n.name)
+}
+
+func (n unaryNode) evaluate(values map[string]int64) (int64, error) {
+	value, err := n.operand.evaluate(values)
+	if err != nil {
+		return -1, err
+	}
+
+	if value == math.MinInt64 {
+		return -1, status.Errorf(codes.InvalidArgument, "integer overflow: -(%v)", value)
+	}
+
+	return -value, nil
+}
+
+func (n binaryNode) evaluate(values map[string]int64) (int64, error) {
+	left, err := n.left.evaluate(values)
+	if err != nil {
+		return -1, err
+	}
+
+	right, err := n.right.evaluate(values)
+	if err != nil {
+		return -1, err
+	}
+
+	switch n.operator {
+	case '+':
+		if (right > 0 && left > math.MaxInt64-right) || (right < 0 && left < math.MinInt64-right) {
+			return -1, status.Errorf(codes.InvalidArgument, "integer overflow: %v + %v", left, right)
+		}
+		return left + right, nil
+	case '-':
+		if (right < 0 && left > math.MaxInt64+right) || (right > 0 && left < math.MinInt64+right) {
+			return -1, status.Errorf(codes.InvalidArgument, "integer overflow: %v - %v", left, right)
+		}
+		return left - right, nil
+	case '*':
+		product := left * right
+		if left != 0 && (product/left != right || (left == -1 && right == math.MinInt64)) {
+			return -1, status.Errorf(codes.InvalidArgument, "integer overflow: %v * %v", left, right)
+		}
+		return product, nil
+	case '/':
+		if right == 0 {
+			return -1, status.Errorf(codes.InvalidArgument, "division by zero: %v / %v", left, right)
+		} else if left == math.MinInt64 && right == -1 {
+			return -1, status.Errorf(codes.InvalidArgument, "integer overflow: %v / %v", left, right)
+		}
+		return left / right, nil
+	default:
+		return -1, status.Errorf(codes.Internal, "unknown operator: %c", n.operator)
+	}
+}
+
+func (n functionNode) evaluate(values map[string]int64) (int64, error) {
+	var result int64
+	for i, argument := range n.arguments {
+		value, err := argument.evaluate(values)
+		if err != nil {
+			return -1, err
+		}
+
+		if i == 0 || (n.name == "max" && value > result) || (n.name == "min" && value < result) {
+			result = value
+		}
+	}
+
+	return result, nil
+}
+
+func (e *metricExpression) String() string {
+	return e.source
+}
+
+func (e *metricExpression) evaluate(values map[string]int64) (int64, error) {
+	return e.root.evaluate(values)
+}
+
+// newMetricNameExpression returns the expression of a single metric without
+// parsing it, so that any metric name stored in Redis can be used as is
+func newMetricNameExpression(metricName string) *metricExpression {
+	return &metricExpression{
+		source:      metricName,
+		root:        metricNode{metricName},
+		metricNames: []string{metricName},
+	}
+}
+
+// getMetricExpression returns the parsed expression, an expression is parsed
+// and validated only once and reused afterwards
+func getMetricExpression(source string) (*metricExpression, error) {
+	expressionsMutex.Lock()
+	defer expressionsMutex.Unlock()
+
+	if expression, exists := expressions[source]; exists {
+		return expression, nil
+	}
+
+	expression, err := parseMetricExpression(source)
+	if err != nil {
+		return nil, err
+	}
+
+	expressions[source] = expression
+	return expression, nil
+}
+
+// Parser
+
+type expressionToken struct {
+	kind  rune // 'n': number, 'i': identifier, 0: end, otherwise the operator itself
+	text  string
+	index int
+}
+
+func tokenizeExpression(source string) ([]expressionToken, error) {
+	tokens := []expressionToken{}
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, expressionToken{'n', string(runes[start:i]), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, expressionToken{'i', string(runes[start:i]), start})
+		case strings.ContainsRune("+-*/(),", r):
+			tokens = append(tokens, expressionToken{r, string(r), i})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at %v", r, i)
+		}
+	}
+
+	tokens = append(tokens, expressionToken{0, "", len(runes)})
+	return tokens, nil
+}
+
+type expressionParser struct {
+	tokens      []expressionToken
+	position    int
+	metricNames []string
+}
+
+func (p *expressionParser) peek() expressionToken {
+	return p.tokens[p.position]
+}
+
+func (p *expressionParser) next() expressionToken {
+	token := p.tokens[p.position]
+	if token.kind != 0 {
+		p.position++
+	}
+	return token
+}
+
+func (p *expressionParser) expect(kind rune) error {
+	if token := p.next(); token.kind != kind {
+		return fmt.Errorf("expected '%c' at %v, got '%v'", kind, token.index, token.text)
+	}
+	return nil
+}
+
+// expression := term {('+' | '-') term}
+func (p *expressionParser) parseExpression() (expressionNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == '+' || p.peek().kind == '-' {
+		operator := p.next().kind
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator, left, right}
+	}
+
+	return left, nil
+}
+
+// term := factor {('*' | '/') factor}
+func (p *expressionParser) parseTerm() (expressionNode, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == '*' || p.peek().kind == '/' {
+		operator := p.next().kind
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{operator, left, right}
+	}
+
+	return left, nil
+}
+
+// factor := number | metric | function '(' expression {',' expression} ')' | '(' expression ')' | '-' factor
+func (p *expressionParser) parseFactor() (expressionNode, error) {
+	token := p.next()
+	switch token.kind {
+	case 'n':
+		value, err := strconv.ParseInt(token.text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%v' at %v", token.text, token.index)
+		}
+		return numberNode{value}, nil
+	case 'i':
+		if p.peek().kind != '(' {
+			if !containsString(p.metricNames, token.text) {
+				p.metricNames = append(p.metricNames, token.text)
+			}
+			return metricNode{token.text}, nil
+		}
+
+		name := strings.ToLower(token.text)
+		if name != "min" && name != "max" {
+			return nil, fmt.Errorf("unknown function '%v' at %v", token.text, token.index)
+		}
+
+		p.next() // '('
+		arguments := []expressionNode{}
+		for {
+			argument, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			arguments = append(arguments, argument)
+
+			if p.peek().kind != ',' {
+				break
+			}
+			p.next()
+		}
+
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+
+		return functionNode{name, arguments}, nil
+	case '(':
+		node, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+
+		return node, nil
+	case '-':
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{operand}, nil
+	case 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected '%v' at %v", token.text, token.index)
+	}
+}
+
+func parseMetricExpression(source string) (*metricExpression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid expression: %v [%v]", source, err.Error())
+	}
+
+	p := &expressionParser{tokens: tokens}
+	root, err := p.parseExpression()
+	if err == nil && p.peek().kind != 0 {
+		err = fmt.Errorf("unexpected '%v' at %v", p.peek().text, p.peek().index)
+	}
+
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid expression: %v [%v]", source, err.Error())
+	}
+
+	return &metricExpression{
+		source:      source,
+		root:        root,
+		metricNames: p.metricNames,
+	}, nil
+}
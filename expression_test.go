@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricExpressionOverflow(t *testing.T) {
+	values := map[string]int64{
+		"big":   9223372036854775807,
+		"small": -9223372036854775808,
+	}
+
+	tests := []struct {
+		expression string
+		value      int64
+		code       codes.Code
+	}{
+		{"big - 1 + 1", 9223372036854775807, codes.OK},
+		{"big + 1", -1, codes.InvalidArgument},
+		{"small - 1", -1, codes.InvalidArgument},
+		{"0 - small", -1, codes.InvalidArgument},
+		{"-small", -1, codes.InvalidArgument},
+		{"big * 2", -1, codes.InvalidArgument},
+		{"-1 * small", -1, codes.InvalidArgument},
+		{"small * -1", -1, codes.InvalidArgument},
+		{"small / -1", -1, codes.InvalidArgument},
+		{"big / 0", -1, codes.InvalidArgument},
+		{"3037000499 * 3037000499", 9223372030926249001, codes.OK},
+	}
+
+	for _, test := range tests {
+		expression, err := getMetricExpression(test.expression)
+		if err != nil {
+			t.Errorf("%v: %v", test.expression, err)
+			continue
+		}
+
+		value, err := expression.evaluate(values)
+		if status.Code(err) != test.code || (err == nil && value != test.value) {
+			t.Errorf("%v: got %v [%v], want %v [%v]", test.expression, value, err, test.value, test.code)
+		}
+	}
+}
+
+func TestScaleMetricExpressionWithScaleMetrics(t *testing.T) {
+	metadata := map[string]string{
+		keyScaleMetrics:          "bytes_out:1000,num_requests_in:50",
+		keyScaleMetricExpression: "bytes_out + num_requests_in",
+	}
+
+	if _, err := getScaleMetricExpression(metadata, keyScaleMetricBytesOut); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, want %v without an explicit %v", err, codes.InvalidArgument, keyScaleMetricName)
+	}
+
+	metadata[keyScaleMetrics] = "weighted:1000,num_requests_in:50"
+	metadata[keyScaleMetricName] = "weighted"
+
+	if expression, err := getScaleMetricExpression(metadata, "weighted"); err != nil || expression.String() != metadata[keyScaleMetricExpression] {
+		t.Errorf("got %v [%v], want the expression", expression, err)
+	}
+
+	if expression, err := getScaleMetricExpression(metadata, keyScaleMetricNumRequestsIn); err != nil || expression.String() != keyScaleMetricNumRequestsIn {
+		t.Errorf("got %v [%v], want the metric itself", expression, err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pb "github.com/iamazeem/cwm-keda-external-scaler/externalscaler"
+	log "github.com/sirupsen/logrus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	fallback = newFallbackCache()
+)
+
+type fallbackPolicy struct {
+	onRedisError string
+	maxStaleness time.Duration
+}
+
+type fallbackValue struct {
+	timestamp time.Time
+	value     int64
+}
+
+// fallbackCache keeps the last successful results reported to KEDA, the
+// active status under the key of the ScaledObject without a metric name and
+// the metric values under the keys of their series
+type fallbackCache struct {
+	mutex  sync.Mutex
+	values map[metricCacheKey]fallbackValue
+}
+
+func newFallbackCache() *fallbackCache {
+	return &fallbackCache{
+		values: make(map[metricCacheKey]fallbackValue),
+	}
+}
+
+func getFallbackPolicy(metadata map[string]string) (fallbackPolicy, error) {
+	onRedisError, err := getOnRedisError(metadata)
+	if err != nil {
+		return fallbackPolicy{}, err
+	}
+
+	maxStalenessSeconds, err := getMaxStalenessSeconds(metadata)
+	if err != nil {
+		return fallbackPolicy{}, err
+	}
+
+	return fallbackPolicy{
+		onRedisError: onRedisError,
+		maxStaleness: time.Duration(maxStalenessSeconds) * time.Second,
+	}, nil
+}
+
+func (c *fallbackCache) set(key metricCacheKey, value int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.values[key] = fallbackValue{
+		timestamp: time.Now().UTC(),
+		value:     value,
+	}
+}
+
+func (c *fallbackCache) get(key metricCacheKey, maxStaleness time.Duration) (int64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	v, exists := c.values[key]
+	if !exists || time.Since(v.timestamp) > maxStaleness {
+		return 0, false
+	}
+
+	return v.value, true
+}
+
+// remove drops the values of a series along with the active status of its ScaledObject
+func (c *fallbackCache) remove(key metricCacheKey) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	activeKey := key
+	activeKey.metricName = ""
+
+	delete(c.values, key)
+	delete(c.values, activeKey)
+}
+
+func newActiveCacheKey(scaledObjectRef *pb.ScaledObjectRef) metricCacheKey {
+	return newMetricCacheKey(scaledObjectRef, "")
+}
+
+func (c *fallbackCache) setActive(scaledObjectRef *pb.ScaledObjectRef, active bool) {
+	var value int64 = 0
+	if active {
+		value = 1
+	}
+
+	c.set(newActiveCacheKey(scaledObjectRef), value)
+}
+
+// isFallbackError returns whether the policy applies to err, only when the
+// source is unavailable and not e.g. for a missing key or an invalid value
+func isFallbackError(ctx context.Context, err error) bool {
+	return getContextError(ctx) == nil && status.Code(err) == codes.Unavailable
+}
+
+// getFallbackActive returns the active status as per the onRedisError policy
+// after reading from Redis failed with err
+func getFallbackActive(ctx context.Context, scaledObjectRef *pb.ScaledObjectRef, policy fallbackPolicy, err error) (bool, error) {
+	if !isFallbackError(ctx, err) {
+		return false, err
+	}
+
+	key := newActiveCacheKey(scaledObjectRef)
+
+	switch policy.onRedisError {
+	case onRedisErrorZero:
+		log.Warnf("[%v] isActive: false [%v = %v] [%v]", key, keyOnRedisError, policy.onRedisError, err.Error())
+		return false, nil
+	case onRedisErrorLastKnown, onRedisErrorHold:
+		if value, ok := fallback.get(key, policy.maxStaleness); ok {
+			active := value == 1
+			log.Warnf("[%v] isActive: %v [%v = %v] [%v]", key, active, keyOnRedisError, policy.onRedisError, err.Error())
+			return active, nil
+		}
+
+		log.Warnf("[%v] no isActive status within %v [%v = %v]", key, policy.maxStaleness, keyMaxStalenessSeconds, policy.maxStaleness.Seconds())
+	}
+
+	return false, err
+}
+
+// getFallbackMetrics returns the metric value as per the onRedisError policy
+// after reading from Redis failed with err. lastKnown computes the value from
+// the cached metric values only, hold repeats the last reported value.
+func getFallbackMetrics(ctx context.Context, key metricCacheKey, policy fallbackPolicy, metricType, windowFunction, metricAggregation string, err error) (metric, error) {
+	if !isFallbackError(ctx, err) {
+		return metric{}, err
+	}
+
+	switch policy.onRedisError {
+	case onRedisErrorZero:
+		log.Warnf("[%v] returning metrics {name: %v, value: 0} [%v = %v] [%v]", key, key.metricName, keyOnRedisError, policy.onRedisError, err.Error())
+		return metric{key.metricName, 0}, nil
+	case onRedisErrorLastKnown:
+		if data, cacheErr := cache.getMetricData(key); cacheErr == nil && time.Since(data[len(data)-1].timestamp) <= policy.maxStaleness {
+			value := getReportedValue(metricType, windowFunction, metricAggregation, data, getMinRateInterval())
+			log.Warnf("[%v] returning metrics {name: %v, value: %v} [%v = %v] [%v]", key, key.metricName, value, keyOnRedisError, policy.onRedisError, err.Error())
+			return metric{key.metricName, value}, nil
+		}
+	case onRedisErrorHold:
+		if value, ok := fallback.get(key, policy.maxStaleness); ok {
+			log.Warnf("[%v] returning metrics {name: %v, value: %v} [%v = %v] [%v]", key, key.metricName, value, keyOnRedisError, policy.onRedisError, err.Error())
+			return metric{key.metricName, value}, nil
+		}
+	default:
+		return metric{}, err
+	}
+
+	log.Warnf("[%v] no metric value within %v [%v = %v]", key, policy.maxStaleness, keyMaxStalenessSeconds, policy.maxStaleness.Seconds())
+
+	return metric{}, err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/iamazeem/cwm-keda-external-scaler/externalscaler"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingSource is a metricSource for the tests that always fails with err
+type failingSource struct {
+	err error
+}
+
+func (s failingSource) getMetricValue(context.Context, map[string]string, string) (int64, error) {
+	return -1, s.err
+}
+
+func (s failingSource) getLastUpdateTime(context.Context, map[string]string) (time.Time, error) {
+	return time.Time{}, s.err
+}
+
+func init() {
+	metricSources["unavailable"] = failingSource{status.Error(codes.Unavailable, "connection refused")}
+	metricSources["missing"] = failingSource{status.Error(codes.NotFound, "key does not exist")}
+}
+
+func TestFallbackOnlyWhenUnavailable(t *testing.T) {
+	tests := []struct {
+		source       string
+		onRedisError string
+		code         codes.Code
+	}{
+		{"unavailable", onRedisErrorError, codes.Unavailable},
+		{"unavailable", onRedisErrorZero, codes.OK},
+		{"missing", onRedisErrorZero, codes.NotFound},
+		{"missing", onRedisErrorHold, codes.NotFound},
+	}
+
+	for _, test := range tests {
+		ref := &pb.ScaledObjectRef{
+			Name:      "fallback-" + test.source + "-" + test.onRedisError,
+			Namespace: "fallback-test",
+			ScalerMetadata: map[string]string{
+				keySource:       test.source,
+				keyOnRedisError: test.onRedisError,
+			},
+		}
+
+		active, err := isActive(context.Background(), ref)
+		if status.Code(err) != test.code || active {
+			t.Errorf("%v/%v: isActive got %v [%v], want %v", test.source, test.onRedisError, active, err, test.code)
+		}
+
+		m, err := getMetrics(context.Background(), ref, defaultScaleMetricName)
+		if status.Code(err) != test.code || (err == nil && m.value != 0) {
+			t.Errorf("%v/%v: getMetrics got %v [%v], want %v", test.source, test.onRedisError, m, err, test.code)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	forecaster = newMetricForecaster()
+)
+
+// Holt-Winters smoothing factors for level, trend and season
+const (
+	forecastAlpha = 0.3
+	forecastBeta  = 0.05
+	forecastGamma = 0.3
+)
+
+// metricHistory holds the increase of a metric per bucket, oldest first.
+// The current bucket is still being filled and is not part of the buckets.
+type metricHistory struct {
+	buckets       []float64
+	currentStart  time.Time
+	currentValue  float64
+	lastValue     int64
+	lastTimestamp time.Time
+}
+
+// metricForecaster retains a longer history than scalePeriodSeconds for the
+// series with a forecastHorizonSeconds and forecasts their per-second rate
+// with an additive Holt-Winters model of the configured seasonality.
+type metricForecaster struct {
+	mutex     sync.Mutex
+	histories map[metricCacheKey]*metricHistory
+
+	bucket  time.Duration
+	season  time.Duration
+	history time.Duration
+}
+
+func newMetricForecaster() *metricForecaster {
+	return &metricForecaster{
+		histories: make(map[metricCacheKey]*metricHistory),
+	}
+}
+
+func (f *metricForecaster) configure() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.bucket = getDurationSecondsFromEnv(keyForecastBucketSeconds, defaultForecastBucketSeconds)
+	f.season = getDurationSecondsFromEnv(keyForecastSeasonSeconds, defaultForecastSeasonSeconds)
+	f.history = getDurationSecondsFromEnv(keyForecastHistorySeconds, defaultForecastHistorySeconds)
+
+	if f.season < f.bucket {
+		f.season = f.bucket
+		log.Warnf("%v is less than %v. using: %v", keyForecastSeasonSeconds, keyForecastBucketSeconds, f.season)
+	}
+
+	log.Infof("forecaster configured [bucket: %v, season: %v, history: %v]", f.bucket, f.season, f.history)
+}
+
+func (f *metricForecaster) getSeasonLength() int {
+	return int(f.season / f.bucket)
+}
+
+func (f *metricForecaster) getMaxBuckets() int {
+	return int(f.history / f.bucket)
+}
+
+// observe adds a new value of a counter to the history of the series
+func (f *metricForecaster) observe(key metricCacheKey, value int64, now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	bucketStart := now.Truncate(f.bucket)
+
+	h, exists := f.histories[key]
+	if !exists {
+		f.histories[key] = &metricHistory{
+			currentStart:  bucketStart,
+			lastValue:     value,
+			lastTimestamp: now,
+		}
+		log.Debugf("[%v] forecast history started", key)
+		return
+	}
+
+	if !now.After(h.lastTimestamp) {
+		return
+	}
+
+	// the increase since the last value is spread evenly over the time passed,
+	// so that a gap e.g. while Redis was unavailable does not end up as a spike
+	// in the current bucket that the seasonal term would repeat
+	increase := float64(getIncrease([]int64{h.lastValue, value}))
+	elapsed := now.Sub(h.lastTimestamp).Seconds()
+	from := h.lastTimestamp
+
+	// close the buckets passed since the last value
+	for h.currentStart.Before(bucketStart) {
+		end := h.currentStart.Add(f.bucket)
+		h.currentValue += increase * end.Sub(from).Seconds() / elapsed
+		h.buckets = append(h.buckets, h.currentValue)
+		h.currentStart = end
+		h.currentValue = 0
+		from = end
+	}
+
+	if maxBuckets := f.getMaxBuckets(); len(h.buckets) > maxBuckets {
+		h.buckets = h.buckets[len(h.buckets)-maxBuckets:]
+	}
+
+	h.currentValue += increase * now.Sub(from).Seconds() / elapsed
+	h.lastValue = value
+	h.lastTimestamp = now
+}
+
+// forecastRate returns the highest forecasted per-second rate within the
+// horizon, false if the history does not cover two seasons yet
+func (f *metricForecaster) forecastRate(key metricCacheKey, horizon time.Duration) (float64, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	h, exists := f.histories[key]
+	if !exists {
+		return 0, false
+	}
+
+	m := f.getSeasonLength()
+	if len(h.buckets) < 2*m {
+		log.Debugf("[%v] not enough forecast history [buckets: %v, required: %v]", key, len(h.buckets), 2*m)
+		return 0, false
+	}
+
+	steps := int(math.Ceil(float64(horizon) / float64(f.bucket)))
+	forecasts := forecastHoltWinters(h.buckets, m, steps)
+
+	increase := 0.0
+	for _, v := range forecasts {
+		increase = math.Max(increase, v)
+	}
+
+	return increase / f.bucket.Seconds(), true
+}
+
+func (f *metricForecaster) remove(key metricCacheKey) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	delete(f.histories, key)
+}
+
+// forecastHoltWinters fits an additive Holt-Winters model with season length m
+// on the values (at least two seasons) and returns the forecasts of the next
+// steps values
+func forecastHoltWinters(values []float64, m, steps int) []float64 {
+	mean := func(v []float64) float64 {
+		sum := 0.0
+		for _, x := range v {
+			sum += x
+		}
+		return sum / float64(len(v))
+	}
+
+	// initialize from the first two seasons
+	level := mean(values[:m])
+	trend := (mean(values[m:2*m]) - level) / float64(m)
+	seasonal := make([]float64, m)
+	for i := 0; i < m; i++ {
+		seasonal[i] = values[i] - level
+	}
+
+	for i := m; i < len(values); i++ {
+		s := seasonal[i%m]
+		lastLevel := level
+		level = forecastAlpha*(values[i]-s) + (1-forecastAlpha)*(level+trend)
+		trend = forecastBeta*(level-lastLevel) + (1-forecastBeta)*trend
+		seasonal[i%m] = forecastGamma*(values[i]-level) + (1-forecastGamma)*s
+	}
+
+	forecasts := make([]float64, 0, steps)
+	for h := 1; h <= steps; h++ {
+		forecasts = append(forecasts, level+float64(h)*trend+seasonal[(len(values)+h-1)%m])
+	}
+
+	return forecasts
+}
+
+// getForecastValue converts a per-second rate to the unit of the value
+// reported for the windowFunction and metricAggregation
+func getForecastValue(windowFunction, metricAggregation string, rate float64, scalePeriodSeconds int64) int64 {
+	switch {
+	case windowFunction == windowFunctionDelta && metricAggregation == metricAggregationDelta:
+		rate *= float64(scalePeriodSeconds)
+	case metricAggregation == metricAggregationRatePerMinute:
+		rate *= time.Minute.Seconds()
+	}
+
+	return int64(math.Round(math.Max(rate, 0)))
+}
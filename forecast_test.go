@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func newTestForecaster(bucket, season, history time.Duration) *metricForecaster {
+	f := newMetricForecaster()
+	f.bucket = bucket
+	f.season = season
+	f.history = history
+	return f
+}
+
+func TestForecastHoltWinters(t *testing.T) {
+	const m = 24
+
+	daily := func(i int) float64 {
+		return 1000 + 500*math.Sin(2*math.Pi*float64(i%m)/m)
+	}
+
+	tests := []struct {
+		name      string
+		value     func(i int) float64
+		tolerance float64
+	}{
+		{"daily pattern", daily, 50},
+		{"flat", func(int) float64 { return 100 }, 1e-9},
+		{"zero", func(int) float64 { return 0 }, 1e-9},
+	}
+
+	for _, test := range tests {
+		values := []float64{}
+		for i := 0; i < 3*m; i++ {
+			values = append(values, test.value(i))
+		}
+
+		forecasts := forecastHoltWinters(values, m, m)
+		if len(forecasts) != m {
+			t.Fatalf("%v: got %v forecasts, want %v", test.name, len(forecasts), m)
+		}
+
+		for h, forecast := range forecasts {
+			if want := test.value(len(values) + h); math.Abs(forecast-want) > test.tolerance {
+				t.Errorf("%v: step %v: got %.2f, want %.2f", test.name, h+1, forecast, want)
+			}
+		}
+	}
+}
+
+func TestForecasterObserve(t *testing.T) {
+	f := newTestForecaster(time.Minute, 10*time.Minute, time.Hour)
+	key := newTestCacheKey("forecast")
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		offset  time.Duration
+		value   int64
+		buckets []float64
+		current float64
+	}{
+		{0, 1000, []float64{}, 0},
+		{30 * time.Second, 1030, []float64{}, 30},
+		{90 * time.Second, 1090, []float64{60}, 30},
+		// a counter reset is an increase of the new value
+		{100 * time.Second, 10, []float64{60}, 40},
+		// a gap of 5 minutes is spread over its buckets instead of a spike
+		{400 * time.Second, 310, []float64{60, 60, 60, 60, 60, 60}, 40},
+	}
+
+	for _, test := range tests {
+		f.observe(key, test.value, start.Add(test.offset))
+
+		h := f.histories[key]
+		if len(h.buckets) != len(test.buckets) {
+			t.Fatalf("%v: got buckets %v, want %v", test.offset, h.buckets, test.buckets)
+		}
+		for i := range h.buckets {
+			if math.Abs(h.buckets[i]-test.buckets[i]) > 1e-9 {
+				t.Errorf("%v: got buckets %v, want %v", test.offset, h.buckets, test.buckets)
+				break
+			}
+		}
+		if math.Abs(h.currentValue-test.current) > 1e-9 {
+			t.Errorf("%v: got current %v, want %v", test.offset, h.currentValue, test.current)
+		}
+	}
+
+	// an older value is ignored
+	f.observe(key, 0, start)
+	if h := f.histories[key]; h.lastValue != 310 {
+		t.Errorf("got last value %v, want 310", h.lastValue)
+	}
+}
+
+// TestForecasterGap checks that a gap in the samples does not forecast a false
+// peak a season later
+func TestForecasterGap(t *testing.T) {
+	f := newTestForecaster(time.Minute, 10*time.Minute, time.Hour)
+	key := newTestCacheKey("forecast-gap")
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// a steady 1/s for three seasons, not sampled for 5 minutes in the middle
+	for offset := time.Duration(0); offset <= 30*time.Minute; offset += 10 * time.Second {
+		if offset > 12*time.Minute && offset < 17*time.Minute {
+			continue
+		}
+		f.observe(key, int64(offset.Seconds()), start.Add(offset))
+	}
+
+	rate, ok := f.forecastRate(key, 10*time.Minute)
+	if !ok {
+		t.Fatal("no forecast with three seasons of history")
+	}
+	if math.Abs(rate-1) > 0.01 {
+		t.Errorf("got rate %.3f, want 1", rate)
+	}
+
+	if _, ok := f.forecastRate(newTestCacheKey("forecast-missing"), time.Minute); ok {
+		t.Error("got a forecast without any history")
+	}
+}
+
+func TestGetForecastValue(t *testing.T) {
+	tests := []struct {
+		windowFunction    string
+		metricAggregation string
+		rate              float64
+		value             int64
+	}{
+		{windowFunctionDelta, metricAggregationDelta, 2.5, 1500},
+		{windowFunctionDelta, metricAggregationRate, 2.5, 3},
+		{windowFunctionDelta, metricAggregationRatePerMinute, 2.5, 150},
+		{windowFunctionMaxRate, metricAggregationDelta, 2.5, 3},
+		{windowFunctionMaxRate, metricAggregationRatePerMinute, 2.5, 150},
+		{windowFunctionDelta, metricAggregationDelta, -1, 0},
+	}
+
+	for _, test := range tests {
+		if value := getForecastValue(test.windowFunction, test.metricAggregation, test.rate, 600); value != test.value {
+			t.Errorf("%v/%v: got %v, want %v", test.windowFunction, test.metricAggregation, value, test.value)
+		}
+	}
+}
@@ -13,102 +13,233 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 )
 
 // Utility functions
 
-func isActive(metadata map[string]string) (bool, error) {
+func isActive(ctx context.Context, scaledObjectRef *pb.ScaledObjectRef) (bool, error) {
 	log.Debug("checking active status")
 
+	metadata := scaledObjectRef.ScalerMetadata
+
 	isActiveTtlSeconds, err := getIsActiveTtlSeconds(metadata)
 	if err != nil {
 		return false, err
 	}
 
-	lastUpdateTime, err := getLastUpdateTime(metadata)
+	policy, err := getFallbackPolicy(metadata)
 	if err != nil {
 		return false, err
 	}
 
-	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
-
-	metric, err := getMetric(metadata)
+	metricType, err := getMetricType(metadata)
 	if err != nil {
 		return false, err
 	}
 
-	scalePeriodSeconds, err := getScalePeriodSeconds(metadata)
-	if err != nil {
+	if _, err := getScalePeriodSeconds(metadata); err != nil {
 		return false, err
 	}
 
-	cache.append(deploymentid, metric, scalePeriodSeconds)
+	if _, err := getScaleMetricNames(metadata); err != nil {
+		return false, err
+	}
 
 	// determine activeness
-	active := int64(time.Since(lastUpdateTime).Seconds()) < isActiveTtlSeconds
+	var active bool
+	if isQueueMetricType(metricType) {
+		if active, err = hasQueueEntries(ctx, metadata); err != nil {
+			return getFallbackActive(ctx, scaledObjectRef, policy, err)
+		}
+	} else {
+		lastUpdateTime, err := getLastUpdateTime(ctx, metadata)
+		if err != nil {
+			return getFallbackActive(ctx, scaledObjectRef, policy, err)
+		}
+		active = int64(time.Since(lastUpdateTime).Seconds()) < isActiveTtlSeconds
+	}
+
+	sampler.track(ctx, scaledObjectRef)
+
 	log.Infof("isActive: %v", active)
 
+	fallback.setActive(scaledObjectRef, active)
+
 	return active, nil
 }
 
-func getMetricSpec(metadata map[string]string) (metric, error) {
+func getMetricSpec(metadata map[string]string) ([]metric, error) {
 	log.Debug("getting metric spec {metric name, target value}")
 
-	scaleMetricName := getValueFromScalerMetadata(metadata, keyScaleMetricName, defaultScaleMetricName)
+	if _, err := getMetricSource(metadata); err != nil {
+		return nil, err
+	}
+
+	if _, err := getMetricType(metadata); err != nil {
+		return nil, err
+	}
+
+	if _, err := getTrimEventWindow(metadata); err != nil {
+		return nil, err
+	}
+
+	if _, err := getMetricAggregation(metadata); err != nil {
+		return nil, err
+	}
+
+	if _, err := getWindowFunction(metadata); err != nil {
+		return nil, err
+	}
+
+	if _, err := getSmoothing(metadata); err != nil {
+		return nil, err
+	}
 
-	targetValueStr := getValueFromScalerMetadata(metadata, keyTargetValue, defaultTargetValue)
-	targetValue, err := parseInt64(targetValueStr)
+	if _, err := getForecastHorizonSeconds(metadata); err != nil {
+		return nil, err
+	}
+
+	if _, err := getFallbackPolicy(metadata); err != nil {
+		return nil, err
+	}
+
+	specs, err := getMetricSpecs(metadata)
 	if err != nil {
-		return metric{}, status.Errorf(codes.InvalidArgument, "could not get metadata value for %v. %v", keyTargetValue, err.Error())
-	} else if targetValue < 0 {
-		return metric{}, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyTargetValue, targetValue)
+		return nil, err
 	}
 
-	log.Infof("returning metric spec {metric name: %v, target value: %v}", scaleMetricName, targetValue)
+	for _, spec := range specs {
+		if _, err := getScaleMetricExpression(metadata, spec.name); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, spec := range specs {
+		log.Infof("returning metric spec {metric name: %v, target value: %v}", spec.name, spec.value)
+	}
 
-	return metric{scaleMetricName, targetValue}, nil
+	return specs, nil
 }
 
-func getMetrics(metadata map[string]string, inMetricName string) (metric, error) {
+func getMetrics(ctx context.Context, scaledObjectRef *pb.ScaledObjectRef, inMetricName string) (metric, error) {
 	log.Debug("getting metrics {name, value}")
 
-	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
-	oldMetricData, err := cache.getOldestMetricData(deploymentid)
+	metricType, err := getMetricType(scaledObjectRef.ScalerMetadata)
+	if err != nil {
+		return metric{}, err
+	}
+
+	metricAggregation, err := getMetricAggregation(scaledObjectRef.ScalerMetadata)
+	if err != nil {
+		return metric{}, err
+	}
+
+	windowFunction, err := getWindowFunction(scaledObjectRef.ScalerMetadata)
 	if err != nil {
 		return metric{}, err
 	}
 
-	newMetric, err := getMetric(metadata)
+	smoothing, err := getSmoothing(scaledObjectRef.ScalerMetadata)
 	if err != nil {
 		return metric{}, err
 	}
 
-	if newMetric.name != inMetricName {
-		return metric{}, status.Errorf(codes.InvalidArgument, "%v changed [%v => %v]", keyScaleMetricName, newMetric.name, inMetricName)
+	smoothingHalfLife, err := getHalfLifeSeconds(scaledObjectRef.ScalerMetadata, keySmoothingHalfLifeSeconds, defaultSmoothingHalfLifeSeconds)
+	if err != nil {
+		return metric{}, err
+	}
+
+	smoothingTrendHalfLife, err := getHalfLifeSeconds(scaledObjectRef.ScalerMetadata, keySmoothingTrendHalfLifeSeconds, defaultSmoothingTrendHalfLifeSeconds)
+	if err != nil {
+		return metric{}, err
+	}
+
+	forecastHorizonSeconds, err := getForecastHorizonSeconds(scaledObjectRef.ScalerMetadata)
+	if err != nil {
+		return metric{}, err
+	}
+
+	scalePeriodSeconds, err := getScalePeriodSeconds(scaledObjectRef.ScalerMetadata)
+	if err != nil {
+		return metric{}, err
+	}
+
+	policy, err := getFallbackPolicy(scaledObjectRef.ScalerMetadata)
+	if err != nil {
+		return metric{}, err
+	}
+
+	scaleMetricNames, err := getScaleMetricNames(scaledObjectRef.ScalerMetadata)
+	if err != nil {
+		return metric{}, err
 	}
 
-	oldMetricValue := oldMetricData.metric.value
-	log.Infof("old metric value: %v", oldMetricValue)
+	if !containsString(scaleMetricNames, inMetricName) {
+		return metric{}, status.Errorf(codes.InvalidArgument, "%v changed [%v => %v]", keyScaleMetricName, strings.Join(scaleMetricNames, ","), inMetricName)
+	}
+
+	sampler.track(ctx, scaledObjectRef)
+
+	key := newMetricCacheKey(scaledObjectRef, inMetricName)
+	newMetric, err := getMetric(ctx, scaledObjectRef.ScalerMetadata, inMetricName)
+	if err != nil {
+		return getFallbackMetrics(ctx, key, policy, metricType, windowFunction, metricAggregation, err)
+	}
+
+	// the first sample of a new series may not be in the cache yet e.g. it is
+	// taken by a concurrent call or it failed, the series then starts with
+	// the new value
+	oldMetricData, err := cache.getMetricData(key)
+	if err != nil {
+		log.Infof("[%v] cache is empty, starting with the new metric value", key)
+		cache.append(key, newMetric, scalePeriodSeconds)
+	} else {
+		log.Infof("old metric value: %v", oldMetricData[0].metric.value)
+	}
 
 	log.Infof("new metric value: %v", newMetric.value)
 
-	metricValueDiff := newMetric.value - oldMetricValue
-	if metricValueDiff < 0 {
-		return metric{}, status.Errorf(codes.InvalidArgument, "invalid metric value: %v, must be positive", metricValueDiff)
+	now := time.Now().UTC()
+	data := append(oldMetricData, metricData{
+		timestamp: now,
+		metric:    newMetric,
+	})
+
+	windowValue := getReportedValue(metricType, windowFunction, metricAggregation, data, getMinRateInterval())
+	metricValue := smoother.smooth(key, smoothing, smoothingHalfLife, smoothingTrendHalfLife, windowValue, now)
+
+	// the forecast is based on the increase of a counter
+	if forecastHorizonSeconds > 0 && metricType == metricTypeCounter {
+		horizon := time.Duration(forecastHorizonSeconds) * time.Second
+		if rate, ok := forecaster.forecastRate(key, horizon); ok {
+			forecastValue := getForecastValue(windowFunction, metricAggregation, rate, scalePeriodSeconds)
+			log.Infof("[%v] forecast value: %v [%v = %v]", key, forecastValue, keyForecastHorizonSeconds, forecastHorizonSeconds)
+			if forecastValue > metricValue {
+				metricValue = forecastValue
+			}
+		}
 	}
 
-	log.Infof("returning metrics {name: %v, value: %v}", newMetric.name, metricValueDiff)
+	fallback.set(key, metricValue)
+
+	log.Infof("returning metrics {name: %v, value: %v} [%v = %v, %v = %v, %v = %v, %v = %v, window value: %v]", newMetric.name, metricValue, keyMetricType, metricType, keyWindowFunction, windowFunction, keyMetricAggregation, metricAggregation, keySmoothing, smoothing, windowValue)
 
-	return metric{newMetric.name, metricValueDiff}, nil
+	return metric{newMetric.name, metricValue}, nil
 }
 
 // External Scaler
 
+var (
+	healthServer = health.NewServer()
+)
+
 type externalScalerServer struct{}
 
-func (s *externalScalerServer) IsActive(_ context.Context, in *pb.ScaledObjectRef) (*pb.IsActiveResponse, error) {
-	result, err := isActive(in.ScalerMetadata)
+func (s *externalScalerServer) IsActive(ctx context.Context, in *pb.ScaledObjectRef) (*pb.IsActiveResponse, error) {
+	result, err := isActive(ctx, in)
 	if err != nil {
 		return nil, err
 	}
@@ -119,25 +250,66 @@ func (s *externalScalerServer) IsActive(_ context.Context, in *pb.ScaledObjectRe
 }
 
 func (s *externalScalerServer) StreamIsActive(in *pb.ScaledObjectRef, stream pb.ExternalScaler_StreamIsActiveServer) error {
-	return nil
+	streamIsActiveIntervalSeconds, err := getStreamIsActiveIntervalSeconds(in.ScalerMetadata)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("[%v/%v] stream started [%v = %v]", in.Namespace, in.Name, keyStreamIsActiveIntervalSeconds, streamIsActiveIntervalSeconds)
+
+	ticker := time.NewTicker(time.Duration(streamIsActiveIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	// the first evaluation is always sent, the subsequent ones only when
+	// the active state flips
+	sent := false
+	lastResult := false
+	for {
+		result, err := isActive(stream.Context(), in)
+		if err != nil {
+			log.Errorf("[%v/%v] stream could not determine active status [%v]", in.Namespace, in.Name, err.Error())
+		} else if !sent || result != lastResult {
+			if err := stream.Send(&pb.IsActiveResponse{Result: result}); err != nil {
+				log.Errorf("[%v/%v] stream send failed [%v]", in.Namespace, in.Name, err.Error())
+				return err
+			}
+
+			log.Infof("[%v/%v] stream sent isActive: %v", in.Namespace, in.Name, result)
+
+			sent = true
+			lastResult = result
+		}
+
+		select {
+		case <-stream.Context().Done():
+			log.Infof("[%v/%v] stream closed", in.Namespace, in.Name)
+			return nil
+		case <-ticker.C:
+		}
+	}
 }
 
 func (s *externalScalerServer) GetMetricSpec(_ context.Context, in *pb.ScaledObjectRef) (*pb.GetMetricSpecResponse, error) {
-	metric, err := getMetricSpec(in.ScalerMetadata)
+	specs, err := getMetricSpec(in.ScalerMetadata)
 	if err != nil {
 		return nil, err
 	}
 
+	metricSpecs := make([]*pb.MetricSpec, 0, len(specs))
+	for _, spec := range specs {
+		metricSpecs = append(metricSpecs, &pb.MetricSpec{
+			MetricName: spec.name,
+			TargetSize: spec.value,
+		})
+	}
+
 	return &pb.GetMetricSpecResponse{
-		MetricSpecs: []*pb.MetricSpec{{
-			MetricName: metric.name,
-			TargetSize: metric.value,
-		}},
+		MetricSpecs: metricSpecs,
 	}, nil
 }
 
-func (s *externalScalerServer) GetMetrics(_ context.Context, in *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
-	metric, err := getMetrics(in.ScaledObjectRef.ScalerMetadata, in.MetricName)
+func (s *externalScalerServer) GetMetrics(ctx context.Context, in *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
+	metric, err := getMetrics(ctx, in.ScaledObjectRef, in.MetricName)
 	if err != nil {
 		return nil, err
 	}
@@ -192,8 +364,21 @@ func main() {
 
 	log.Infof("gRPC server started listening on %v", grpcAddress)
 
+	if webhookAddress := getEnv(keyWebhookAddress, defaultWebhookAddress); webhookAddress != "" {
+		go runWebhookServer(webhookAddress)
+	}
+
+	forecaster.configure()
+	go sampler.run()
+
 	grpcServer := grpc.NewServer()
 	pb.RegisterExternalScalerServer(grpcServer, &externalScalerServer{})
+
+	// the overall status ("") is always serving, the status of the Redis
+	// connection is reported for the "redis" service
+	healthServer.SetServingStatus(redisHealthService, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	go runRedisHealthChecks()
 	if err := grpcServer.Serve(listener); err != nil {
 		log.Fatal(err.Error())
 	}
@@ -1,31 +1,211 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/go-redis/redis/v8"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	redisStreamRangeCount = 1000  // entries per XRANGE while counting the entries of a stream
+	redisStreamMaxCount   = 10000 // entries counted at most, in 10 round trips
 )
 
 var (
-	rdb *redis.Client = nil
+	rdb            redis.UniversalClient = nil // *redis.Client or *redis.ClusterClient depending on the mode
+	rdbMutex       sync.Mutex                  // guards the connection state from concurrent gRPC calls and health checks
+	rdbState       = redisStateDisconnected
+	rdbFailures    = 0
+	rdbNextAttempt time.Time
+	rdbConnecting  chan struct{} // closed when the connection attempt in progress is done, nil if there is none
+
+	// the jitter source is seeded per process so that the replicas do not
+	// retry in lockstep, it must be used with rdbMutex held
+	rdbRand = rand.New(rand.NewSource(time.Now().UnixNano()))
 )
 
-func connectToRedisServer() bool {
-	// return true if already connected
+// getRedisClient returns the connected client, connecting first if needed.
+// A single connection attempt is made at a time in the background, the callers
+// wait for its result as long as their context allows. After a failed attempt,
+// no new attempt is made until its backoff elapses.
+func getRedisClient(ctx context.Context) (redis.UniversalClient, error) {
+	rdbMutex.Lock()
+
+	// return if already connected
 	if rdb != nil {
-		return true
+		client := rdb
+		rdbMutex.Unlock()
+		return client, nil
+	}
+
+	connecting := rdbConnecting
+	if connecting == nil {
+		if now := time.Now(); now.Before(rdbNextAttempt) {
+			nextAttempt := rdbNextAttempt
+			rdbMutex.Unlock()
+			log.Debugf("not connecting with Redis server, backoff until %v", nextAttempt.UTC())
+			return nil, status.Errorf(codes.Unavailable, "not connected with Redis server, backoff until %v", nextAttempt.UTC())
+		}
+
+		connecting = make(chan struct{})
+		rdbConnecting = connecting
+		go connectRedisClient(connecting)
 	}
+	rdbMutex.Unlock()
+
+	select {
+	case <-connecting:
+	case <-ctx.Done():
+		return nil, getContextError(ctx)
+	}
+
+	rdbMutex.Lock()
+	client := rdb
+	rdbMutex.Unlock()
+
+	if client == nil {
+		return nil, status.Error(codes.Unavailable, "could not connect with Redis server")
+	}
+
+	return client, nil
+}
 
+// connectRedisClient makes a connection attempt and closes connecting once it
+// is done. The attempt is not bound to the context of a caller, so that a
+// caller giving up early does not put the connection into backoff, the dial
+// and read timeouts bound it instead.
+func connectRedisClient(connecting chan struct{}) {
 	log.Debug("connecting with Redis server")
 
-	redisHost := getEnv(keyRedisHost, defaultRedisHost)
-	redisPort := getEnv(keyRedisPort, defaultRedisPort)
-	address := redisHost + ":" + redisPort
+	client, address, err := newRedisClient()
+	if err == nil && !pingRedisServer(context.Background(), client) {
+		client.Close()
+		err = fmt.Errorf("ping failed [%v]", address)
+	}
+
+	rdbMutex.Lock()
+	defer rdbMutex.Unlock()
+
+	rdbConnecting = nil
+	defer close(connecting)
+
+	if err != nil {
+		scheduleRedisReconnect(err.Error())
+		return
+	}
+
+	rdb = client
+	rdbFailures = 0
+	setRedisState(redisStateConnected, address)
+
+	log.Debugf("connected with Redis server [%v]", address)
+}
+
+// scheduleRedisReconnect must be called with rdbMutex held
+func scheduleRedisReconnect(reason string) {
+	rdbFailures++
+	backoff := getRedisBackoff(rdbFailures)
+	rdbNextAttempt = time.Now().Add(backoff)
+	setRedisState(redisStateBackoff, fmt.Sprintf("%v. attempt: %v, retrying in %v", reason, rdbFailures, backoff))
+}
+
+// getRedisBackoff returns the exponential backoff after the given number of
+// consecutive failures, with a random jitter of up to half of it. It must be
+// called with rdbMutex held.
+func getRedisBackoff(failures int) time.Duration {
+	minBackoff := getDurationSecondsFromEnv(keyRedisBackoffMinSeconds, defaultRedisBackoffMinSeconds)
+	maxBackoff := getDurationSecondsFromEnv(keyRedisBackoffMaxSeconds, defaultRedisBackoffMaxSeconds)
+
+	backoff := minBackoff
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	return backoff/2 + time.Duration(rdbRand.Int63n(int64(backoff/2)+1))
+}
+
+// setRedisState must be called with rdbMutex held
+func setRedisState(state, details string) {
+	if state != rdbState {
+		if state == redisStateConnected {
+			log.Infof("Redis connection state: %v => %v [%v]", rdbState, state, details)
+		} else {
+			log.Warnf("Redis connection state: %v => %v [%v]", rdbState, state, details)
+		}
+	}
+
+	rdbState = state
+
+	if state == redisStateConnected {
+		healthServer.SetServingStatus(redisHealthService, healthpb.HealthCheckResponse_SERVING)
+	} else {
+		healthServer.SetServingStatus(redisHealthService, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+// checkRedisHealth pings the connected client and drops it if the ping fails,
+// or (re)connects if there is no client and its backoff has elapsed
+func checkRedisHealth() {
+	rdbMutex.Lock()
+	client := rdb
+	rdbMutex.Unlock()
+
+	if client == nil {
+		getRedisClient(context.Background())
+		return
+	}
 
-	// create new Redis client if one does not exist already
+	if pingRedisServer(context.Background(), client) {
+		return
+	}
+
+	rdbMutex.Lock()
+	defer rdbMutex.Unlock()
+
+	if rdb == client {
+		rdb = nil
+		client.Close()
+		scheduleRedisReconnect("health check failed")
+	}
+}
+
+func runRedisHealthChecks() {
+	interval := getDurationSecondsFromEnv(keyRedisHealthCheckIntervalSeconds, defaultRedisHealthCheckIntervalSeconds)
+
+	log.Infof("Redis health checks started [interval: %v]", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	checkRedisHealth()
+	for range ticker.C {
+		checkRedisHealth()
+	}
+}
+
+// newRedisClient creates a client as per the mode, it returns the address(es)
+// of the Redis server for logging
+func newRedisClient() (redis.UniversalClient, string, error) {
 	redisDbStr := getEnv(keyRedisDb, defaultRedisDb)
 	redisDb, err := strconv.Atoi(redisDbStr)
 	if err != nil {
@@ -33,27 +213,167 @@ func connectToRedisServer() bool {
 		log.Warnf("invalid redis db %v. err: %v. using default db: %v", redisDbStr, err.Error(), redisDb)
 	}
 
-	rdb = redis.NewClient(&redis.Options{
-		Addr:     address,
-		Password: "", // no password set
-		DB:       redisDb,
-	})
+	redisPassword, err := getRedisPassword()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not get Redis password [%v]", err.Error())
+	}
 
-	if !pingRedisServer() {
-		rdb.Close()
-		rdb = nil
-		return false
+	tlsConfig, err := getRedisTlsConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not set up TLS for Redis [%v]", err.Error())
 	}
 
-	log.Debugf("connected with Redis server [%v]", address)
+	redisUsername := getEnv(keyRedisUsername, defaultRedisUsername)
 
-	return true
+	dialTimeout := getDurationSecondsFromEnv(keyRedisDialTimeoutSeconds, defaultRedisDialTimeoutSeconds)
+	readTimeout := getDurationSecondsFromEnv(keyRedisReadTimeoutSeconds, defaultRedisReadTimeoutSeconds)
+	writeTimeout := getDurationSecondsFromEnv(keyRedisWriteTimeoutSeconds, defaultRedisWriteTimeoutSeconds)
+
+	// 0 leaves the go-redis default i.e. 10 connections per CPU
+	redisPoolSizeStr := getEnv(keyRedisPoolSize, defaultRedisPoolSize)
+	redisPoolSize, err := strconv.Atoi(redisPoolSizeStr)
+	if err != nil || redisPoolSize < 0 {
+		redisPoolSize = 0
+		log.Warnf("invalid %v: %v. using default: %v", keyRedisPoolSize, redisPoolSizeStr, defaultRedisPoolSize)
+	}
+
+	switch redisMode := getRedisMode(); redisMode {
+	case redisModeSentinel:
+		masterName := getEnv(keyRedisMasterName, defaultRedisMasterName)
+		sentinelAddrs := getAddressesFromEnv(keyRedisSentinelAddrs, defaultRedisSentinelAddrs)
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       masterName,
+			SentinelAddrs:    sentinelAddrs,
+			SentinelPassword: getEnv(keyRedisSentinelPassword, defaultRedisSentinelPassword),
+			Username:         redisUsername,
+			Password:         redisPassword,
+			DB:               redisDb,
+			TLSConfig:        tlsConfig,
+			DialTimeout:      dialTimeout,
+			ReadTimeout:      readTimeout,
+			WriteTimeout:     writeTimeout,
+			PoolSize:         redisPoolSize,
+		}), fmt.Sprintf("%v %v", masterName, sentinelAddrs), nil
+	case redisModeCluster:
+		clusterAddrs := getAddressesFromEnv(keyRedisClusterAddrs, defaultRedisClusterAddrs)
+		if redisDb != 0 {
+			log.Warnf("%v is not supported in %v mode, using db: 0", keyRedisDb, redisModeCluster)
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        clusterAddrs,
+			Username:     redisUsername,
+			Password:     redisPassword,
+			TLSConfig:    tlsConfig,
+			DialTimeout:  dialTimeout,
+			ReadTimeout:  readTimeout,
+			WriteTimeout: writeTimeout,
+			PoolSize:     redisPoolSize,
+		}), fmt.Sprintf("%v", clusterAddrs), nil
+	default:
+		redisHost := getEnv(keyRedisHost, defaultRedisHost)
+		redisPort := getEnv(keyRedisPort, defaultRedisPort)
+		address := redisHost + ":" + redisPort
+		return redis.NewClient(&redis.Options{
+			Addr:         address,
+			Username:     redisUsername,
+			Password:     redisPassword,
+			DB:           redisDb,
+			TLSConfig:    tlsConfig,
+			DialTimeout:  dialTimeout,
+			ReadTimeout:  readTimeout,
+			WriteTimeout: writeTimeout,
+			PoolSize:     redisPoolSize,
+		}), address, nil
+	}
+}
+
+func getRedisMode() string {
+	redisMode := getEnv(keyRedisMode, defaultRedisMode)
+	switch redisMode {
+	case redisModeStandalone, redisModeSentinel, redisModeCluster:
+		return redisMode
+	default:
+		log.Warnf("invalid %v: %v. using default: %v", keyRedisMode, redisMode, defaultRedisMode)
+		return defaultRedisMode
+	}
+}
+
+// getAddressesFromEnv returns a comma-separated list of host:port addresses
+func getAddressesFromEnv(key, defaultValue string) []string {
+	addresses := []string{}
+	for _, address := range strings.Split(getEnv(key, defaultValue), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
+// getRedisPassword returns the password from the password file if it is set,
+// otherwise from the environment variable
+func getRedisPassword() (string, error) {
+	redisPasswordFile := getEnv(keyRedisPasswordFile, defaultRedisPasswordFile)
+	if redisPasswordFile == "" {
+		return getEnv(keyRedisPassword, defaultRedisPassword), nil
+	}
+
+	password, err := ioutil.ReadFile(redisPasswordFile)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(password), "\r\n"), nil
 }
 
-func pingRedisServer() bool {
+// getRedisTlsConfig returns nil if TLS is not enabled
+func getRedisTlsConfig() (*tls.Config, error) {
+	if !getBoolFromEnv(keyRedisTls, defaultRedisTls) {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         getEnv(keyRedisTlsServerName, defaultRedisTlsServerName),
+		InsecureSkipVerify: getBoolFromEnv(keyRedisTlsInsecureSkipVerify, defaultRedisTlsInsecureSkipVerify),
+	}
+
+	if tlsConfig.InsecureSkipVerify {
+		log.Warnf("TLS certificate verification of Redis server is disabled [%v]", keyRedisTlsInsecureSkipVerify)
+	}
+
+	if caFile := getEnv(keyRedisTlsCaFile, defaultRedisTlsCaFile); caFile != "" {
+		caCert, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no valid certificates in %v: %v", keyRedisTlsCaFile, caFile)
+		}
+	}
+
+	certFile := getEnv(keyRedisTlsCertFile, defaultRedisTlsCertFile)
+	keyFile := getEnv(keyRedisTlsKeyFile, defaultRedisTlsKeyFile)
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	log.Debug("TLS enabled for Redis server")
+
+	return tlsConfig, nil
+}
+
+func pingRedisServer(ctx context.Context, client redis.UniversalClient) bool {
 	log.Debug("pinging Redis server")
 
-	val, err := rdb.Ping(rdb.Context()).Result()
+	val, err := client.Ping(ctx).Result()
 	switch {
 	case err == redis.Nil:
 		return false
@@ -73,28 +393,426 @@ func pingRedisServer() bool {
 	return true
 }
 
-func getValueFromRedisServer(key string) (string, bool) {
+// getRedisCallError converts the error of a Redis call. The errors replied by
+// the server are InvalidArgument e.g. WRONGTYPE, except the ones of a server
+// that is not available yet. The other errors are client or network errors
+// and Unavailable, the onRedisError fallback only applies to them.
+func getRedisCallError(ctx context.Context, call, target string, err error) error {
+	if err := getContextError(ctx); err != nil {
+		return err
+	}
+
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		for _, prefix := range []string{"LOADING", "READONLY", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN"} {
+			if strings.HasPrefix(err.Error(), prefix) {
+				return status.Errorf(codes.Unavailable, "%v call failed for '%v': %v", call, target, err.Error())
+			}
+		}
+		return status.Errorf(codes.InvalidArgument, "%v call failed for '%v': %v", call, target, err.Error())
+	}
+
+	return status.Errorf(codes.Unavailable, "%v call failed for '%v': %v", call, target, err.Error())
+}
+
+func getValueFromRedisServer(ctx context.Context, key string) (string, error) {
 	log.Debugf("getting '%v' from Redis server", key)
 
-	if !connectToRedisServer() {
-		log.Error("could not connect with Redis server")
-		return "", false
+	client, err := getRedisClient(ctx)
+	if err != nil {
+		log.Errorf("could not connect with Redis server [%v]", err.Error())
+		return "", err
 	}
 
-	val, err := rdb.Get(rdb.Context(), key).Result()
+	val, err := client.Get(ctx, key).Result()
 	switch {
 	case err == redis.Nil:
 		log.Errorf("key does not exist [%v]", key)
-		return val, false
+		return val, status.Errorf(codes.NotFound, "key does not exist: %v", key)
 	case err != nil:
 		log.Errorf("get call failed for '%v'! %v", key, err.Error())
-		return val, false
+		return val, getRedisCallError(ctx, "get", key, err)
 	case val == "":
 		log.Errorf("empty value for '%v'", key)
-		return val, false
+		return val, status.Errorf(codes.InvalidArgument, "empty value for '%v'", key)
 	}
 
 	log.Debugf("got: [%v = %v]", key, val)
 
-	return val, true
+	return val, nil
+}
+
+// getValuesFromRedisServer reads all the keys with a single MGET so that the
+// values are a consistent snapshot and cost a single round trip. In cluster
+// mode, the keys are read with pipelined GETs that are not atomic across the
+// hash slots, so the values are not a consistent snapshot there.
+func getValuesFromRedisServer(ctx context.Context, keys []string) ([]string, error) {
+	log.Debugf("getting %v from Redis server", keys)
+
+	client, err := getRedisClient(ctx)
+	if err != nil {
+		log.Errorf("could not connect with Redis server [%v]", err.Error())
+		return nil, err
+	}
+
+	// the keys of different metrics generally map to different hash slots in
+	// cluster mode where MGET would fail with CROSSSLOT, so they are read with
+	// pipelined GETs instead, still in a single round trip per node
+	if _, isCluster := client.(*redis.ClusterClient); isCluster {
+		return getValuesFromRedisCluster(ctx, client, keys)
+	}
+
+	vals, err := client.MGet(ctx, keys...).Result()
+	if err != nil {
+		log.Errorf("mget call failed for %v! %v", keys, err.Error())
+		return nil, getRedisCallError(ctx, "mget", strings.Join(keys, " "), err)
+	}
+
+	return parseRedisValues(keys, vals)
+}
+
+func getValuesFromRedisCluster(ctx context.Context, client redis.UniversalClient, keys []string) ([]string, error) {
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringCmd, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, pipe.Get(ctx, key))
+	}
+
+	pipe.Exec(ctx)
+
+	vals := make([]interface{}, 0, len(keys))
+	for i, cmd := range cmds {
+		val, err := cmd.Result()
+		switch {
+		case err == redis.Nil:
+			vals = append(vals, nil)
+		case err != nil:
+			log.Errorf("pipelined get call failed for '%v'! %v", keys[i], err.Error())
+			return nil, getRedisCallError(ctx, "get", keys[i], err)
+		default:
+			vals = append(vals, val)
+		}
+	}
+
+	return parseRedisValues(keys, vals)
+}
+
+func getHashValueFromRedisServer(ctx context.Context, key, field string) (string, error) {
+	log.Debugf("getting '%v' of '%v' from Redis server", field, key)
+
+	client, err := getRedisClient(ctx)
+	if err != nil {
+		log.Errorf("could not connect with Redis server [%v]", err.Error())
+		return "", err
+	}
+
+	val, err := client.HGet(ctx, key, field).Result()
+	switch {
+	case err == redis.Nil:
+		log.Errorf("field does not exist [%v %v]", key, field)
+		return val, status.Errorf(codes.NotFound, "field does not exist: %v %v", key, field)
+	case err != nil:
+		log.Errorf("hget call failed for '%v %v'! %v", key, field, err.Error())
+		return val, getRedisCallError(ctx, "hget", key+" "+field, err)
+	case val == "":
+		log.Errorf("empty value for '%v %v'", key, field)
+		return val, status.Errorf(codes.InvalidArgument, "empty value for '%v %v'", key, field)
+	}
+
+	log.Debugf("got: [%v %v = %v]", key, field, val)
+
+	return val, nil
+}
+
+// getHashValuesFromRedisServer reads all the fields of a hash with a single HMGET
+func getHashValuesFromRedisServer(ctx context.Context, key string, fields []string) ([]string, error) {
+	log.Debugf("getting %v of '%v' from Redis server", fields, key)
+
+	client, err := getRedisClient(ctx)
+	if err != nil {
+		log.Errorf("could not connect with Redis server [%v]", err.Error())
+		return nil, err
+	}
+
+	vals, err := client.HMGet(ctx, key, fields...).Result()
+	if err != nil {
+		log.Errorf("hmget call failed for '%v' %v! %v", key, fields, err.Error())
+		return nil, getRedisCallError(ctx, "hmget", key, err)
+	}
+
+	return parseRedisValues(fields, vals)
+}
+
+// getEventWindowFromRedisServer returns the number (zcount) or the sum of the
+// values (zsum) of the events of a sorted set scored by unix timestamp within
+// [min, max]. The members of zsum must be "<id>:<value>" with a unique id, as
+// the events with the same member would collapse into one, and a positive
+// value. With trim, the
+// events older than min are removed in the same round trip.
+func getEventWindowFromRedisServer(ctx context.Context, key, metricType string, min, max float64, trim bool) (int64, error) {
+	minStr := strconv.FormatFloat(min, 'f', -1, 64)
+	maxStr := strconv.FormatFloat(max, 'f', -1, 64)
+
+	log.Debugf("getting %v of '%v' within [%v, %v] from Redis server", metricType, key, minStr, maxStr)
+
+	client, err := getRedisClient(ctx)
+	if err != nil {
+		log.Errorf("could not connect with Redis server [%v]", err.Error())
+		return -1, err
+	}
+
+	pipe := client.Pipeline()
+	if trim {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", "("+minStr)
+	}
+
+	var countCmd *redis.IntCmd
+	var membersCmd *redis.StringSliceCmd
+	if metricType == metricTypeZSum {
+		membersCmd = pipe.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: minStr, Max: maxStr})
+	} else {
+		countCmd = pipe.ZCount(ctx, key, minStr, maxStr)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Errorf("%v call failed for '%v'! %v", metricType, key, err.Error())
+		return -1, getRedisCallError(ctx, metricType, key, err)
+	}
+
+	if countCmd != nil {
+		count := countCmd.Val()
+		log.Debugf("got: [%v %v = %v]", metricType, key, count)
+		return count, nil
+	}
+
+	var sum int64 = 0
+	for _, member := range membersCmd.Val() {
+		index := strings.LastIndex(member, ":")
+		if index <= 0 {
+			log.Errorf("invalid event member for '%v' [%v]", key, member)
+			return -1, status.Errorf(codes.InvalidArgument, "invalid event member for '%v': %v, must be <id>:<value>", key, member)
+		}
+
+		value, err := strconv.ParseInt(member[index+1:], 10, 64)
+		if err != nil || value < 0 {
+			log.Errorf("invalid event value for '%v' [%v]", key, member)
+			return -1, status.Errorf(codes.InvalidArgument, "invalid event value for '%v': %v, must be a positive integer", key, member)
+		}
+
+		if sum > math.MaxInt64-value {
+			log.Errorf("integer overflow of the event values for '%v' [%v + %v]", key, sum, value)
+			return -1, status.Errorf(codes.InvalidArgument, "integer overflow of the event values for '%v': %v + %v", key, sum, value)
+		}
+		sum += value
+	}
+
+	log.Debugf("got: [%v %v = %v]", metricType, key, sum)
+
+	return sum, nil
+}
+
+// getQueueLengthFromRedisServer returns the length of a list (llen) or a stream
+// (xlen), or the number of entries of a stream not acknowledged by a consumer
+// group yet (xpending)
+func getQueueLengthFromRedisServer(ctx context.Context, key, metricType, consumerGroup string) (int64, error) {
+	log.Debugf("getting %v of '%v' from Redis server", metricType, key)
+
+	client, err := getRedisClient(ctx)
+	if err != nil {
+		log.Errorf("could not connect with Redis server [%v]", err.Error())
+		return -1, err
+	}
+
+	var length int64
+	switch metricType {
+	case metricTypeLLen:
+		length, err = client.LLen(ctx, key).Result()
+	case metricTypeXLen:
+		length, err = client.XLen(ctx, key).Result()
+	case metricTypeXPending:
+		return getConsumerGroupLagFromRedisServer(ctx, client, key, consumerGroup)
+	}
+
+	if err != nil {
+		log.Errorf("%v call failed for '%v'! %v", metricType, key, err.Error())
+		return -1, getRedisCallError(ctx, metricType, key, err)
+	}
+
+	log.Debugf("got: [%v %v = %v]", metricType, key, length)
+
+	return length, nil
+}
+
+// getRedisReplyFields returns the fields of a reply of XINFO as a map
+func getRedisReplyFields(reply interface{}) map[string]interface{} {
+	values, _ := reply.([]interface{})
+	fields := make(map[string]interface{}, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		if field, ok := values[i].(string); ok {
+			fields[field] = values[i+1]
+		}
+	}
+
+	return fields
+}
+
+// getConsumerGroupLagFromRedisServer returns the entries of a stream not
+// delivered to a consumer group yet (lag) plus the delivered ones not
+// acknowledged yet (pending). The lag is reported by XINFO GROUPS since Redis
+// 7. When Redis cannot determine it, it is estimated from the entries added to
+// the stream and the entries read by the group. Before Redis 7, the entries
+// after the last delivered id of the group are counted up to
+// redisStreamMaxCount.
+func getConsumerGroupLagFromRedisServer(ctx context.Context, client redis.UniversalClient, key, consumerGroup string) (int64, error) {
+	reply, err := client.Do(ctx, "XINFO", "GROUPS", key).Result()
+	if err != nil {
+		log.Errorf("%v call failed for '%v'! %v", metricTypeXPending, key, err.Error())
+		return -1, getRedisCallError(ctx, metricTypeXPending, key, err)
+	}
+
+	groups, _ := reply.([]interface{})
+
+	for _, group := range groups {
+		info := getRedisReplyFields(group)
+		if name, _ := info["name"].(string); name != consumerGroup {
+			continue
+		}
+
+		pending, _ := info["pending"].(int64)
+		lag, exists := info["lag"].(int64)
+		if entriesRead, ok := info["entries-read"].(int64); !exists && ok {
+			if lag, err = estimateStreamLagFromRedisServer(ctx, client, key, entriesRead); err != nil {
+				return -1, err
+			}
+		} else if !exists {
+			lastDeliveredID, _ := info["last-delivered-id"].(string)
+			if lag, err = countStreamEntriesFromRedisServer(ctx, client, key, lastDeliveredID); err != nil {
+				return -1, err
+			}
+		}
+
+		log.Debugf("got: [%v %v %v = %v lag + %v pending]", metricTypeXPending, key, consumerGroup, lag, pending)
+
+		return lag + pending, nil
+	}
+
+	log.Errorf("consumer group does not exist for '%v' [%v]", key, consumerGroup)
+	return -1, status.Errorf(codes.InvalidArgument, "consumer group does not exist for '%v': %v", key, consumerGroup)
+}
+
+// estimateStreamLagFromRedisServer returns the entries added to a stream but
+// not read by a consumer group, at most the length of the stream. Redis 7 does
+// not report the lag of a group e.g. after entries were deleted in its range.
+func estimateStreamLagFromRedisServer(ctx context.Context, client redis.UniversalClient, key string, entriesRead int64) (int64, error) {
+	reply, err := client.Do(ctx, "XINFO", "STREAM", key).Result()
+	if err != nil {
+		log.Errorf("%v call failed for '%v'! %v", metricTypeXPending, key, err.Error())
+		return -1, getRedisCallError(ctx, metricTypeXPending, key, err)
+	}
+
+	info := getRedisReplyFields(reply)
+	length, _ := info["length"].(int64)
+	entriesAdded, ok := info["entries-added"].(int64)
+	if !ok {
+		return length, nil
+	}
+
+	lag := entriesAdded - entriesRead
+	if lag < 0 {
+		lag = 0
+	} else if lag > length {
+		lag = length
+	}
+
+	return lag, nil
+}
+
+// countStreamEntriesFromRedisServer returns the number of entries of a stream
+// after the given id up to redisStreamMaxCount, reading them in batches of
+// redisStreamRangeCount, as it runs on every sample of the metric
+func countStreamEntriesFromRedisServer(ctx context.Context, client redis.UniversalClient, key, afterID string) (int64, error) {
+	if afterID == "" || afterID == "0-0" {
+		count, err := client.XLen(ctx, key).Result()
+		if err != nil {
+			log.Errorf("%v call failed for '%v'! %v", metricTypeXLen, key, err.Error())
+			return -1, getRedisCallError(ctx, metricTypeXLen, key, err)
+		}
+		return count, nil
+	}
+
+	var count int64 = 0
+	for {
+		start, err := getNextStreamID(afterID)
+		if err != nil {
+			log.Errorf("invalid stream id for '%v' [%v]", key, afterID)
+			return -1, status.Errorf(codes.InvalidArgument, "invalid stream id for '%v': %v", key, afterID)
+		}
+
+		entries, err := client.XRangeN(ctx, key, start, "+", redisStreamRangeCount).Result()
+		if err != nil {
+			log.Errorf("xrange call failed for '%v'! %v", key, err.Error())
+			return -1, getRedisCallError(ctx, "xrange", key, err)
+		}
+
+		count += int64(len(entries))
+		if len(entries) < redisStreamRangeCount {
+			return count, nil
+		} else if count >= redisStreamMaxCount {
+			log.Debugf("counted %v entries of '%v', stopping", count, key)
+			return count, nil
+		}
+		afterID = entries[len(entries)-1].ID
+	}
+}
+
+// getNextStreamID returns the smallest stream id after "<ms>-<seq>", XRANGE
+// takes an exclusive start only since Redis 6.2
+func getNextStreamID(id string) (string, error) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid stream id: %v", id)
+	}
+
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return "", err
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	if seq < math.MaxUint64 {
+		return fmt.Sprintf("%v-%v", ms, seq+1), nil
+	}
+	if ms < math.MaxUint64 {
+		return fmt.Sprintf("%v-0", ms+1), nil
+	}
+	return "", fmt.Errorf("no stream id after %v", id)
+}
+
+// parseRedisValues converts the reply of MGET/HMGET for the given keys/fields,
+// all of them must exist and be non-empty
+func parseRedisValues(names []string, vals []interface{}) ([]string, error) {
+	values := make([]string, len(names))
+	for i, val := range vals {
+		switch v := val.(type) {
+		case nil:
+			log.Errorf("key does not exist [%v]", names[i])
+			return nil, status.Errorf(codes.NotFound, "key does not exist: %v", names[i])
+		case string:
+			if v == "" {
+				log.Errorf("empty value for '%v'", names[i])
+				return nil, status.Errorf(codes.InvalidArgument, "empty value for '%v'", names[i])
+			}
+			values[i] = v
+		default:
+			log.Errorf("unexpected value type for '%v' [%T]", names[i], val)
+			return nil, status.Errorf(codes.InvalidArgument, "unexpected value type for '%v': %T", names[i], val)
+		}
+	}
+
+	log.Debugf("got: %v = %v", names, values)
+
+	return values, nil
 }
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeRedisServer is a local Redis stand-in speaking enough RESP for the
+// tests: PING, AUTH, SELECT, GET and MGET, other commands get the replies set
+// with reply. It counts the commands and the round trips i.e. the batches of
+// commands read before replying.
+type fakeRedisServer struct {
+	listener   net.Listener
+	password   string
+	mutex      sync.Mutex
+	values     map[string]string
+	replies    map[string]string // map: command => RESP reply
+	commands   int64
+	roundTrips int64
+}
+
+func newFakeRedisServer(tb testing.TB, tlsConfig *tls.Config, password string) *fakeRedisServer {
+	var listener net.Listener
+	var err error
+	if tlsConfig != nil {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	s := &fakeRedisServer{
+		listener: listener,
+		password: password,
+		values:   make(map[string]string),
+		replies:  make(map[string]string),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	tb.Cleanup(func() { listener.Close() })
+
+	return s
+}
+
+func (s *fakeRedisServer) set(key, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.values[key] = value
+}
+
+func (s *fakeRedisServer) get(key string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if value, exists := s.values[key]; exists {
+		return fmt.Sprintf("$%v\r\n%v\r\n", len(value), value)
+	}
+
+	return "$-1\r\n"
+}
+
+// reply sets the RESP reply of a command other than the built-in ones, the
+// subcommands are set as e.g. "XINFO STREAM"
+func (s *fakeRedisServer) reply(command, reply string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.replies[command] = reply
+}
+
+func (s *fakeRedisServer) getReply(command string) (string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reply, exists := s.replies[command]
+	return reply, exists
+}
+
+// respArray returns the RESP array of the bulk strings
+func respArray(values ...string) string {
+	reply := fmt.Sprintf("*%v\r\n", len(values))
+	for _, value := range values {
+		reply += fmt.Sprintf("$%v\r\n%v\r\n", len(value), value)
+	}
+	return reply
+}
+
+func readRespCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "$")))
+		if err != nil {
+			return nil, err
+		}
+
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args = append(args, string(arg[:size]))
+	}
+
+	return args, nil
+}
+
+func (s *fakeRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	authenticated := s.password == ""
+	for {
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+			if _, err := r.Peek(1); err != nil {
+				return
+			}
+			atomic.AddInt64(&s.roundTrips, 1)
+		}
+
+		args, err := readRespCommand(r)
+		if err != nil || len(args) == 0 {
+			return
+		}
+
+		atomic.AddInt64(&s.commands, 1)
+
+		command := strings.ToUpper(args[0])
+		switch {
+		case command == "AUTH":
+			if args[len(args)-1] == s.password {
+				authenticated = true
+				w.WriteString("+OK\r\n")
+			} else {
+				w.WriteString("-WRONGPASS invalid username-password pair\r\n")
+			}
+		case !authenticated:
+			w.WriteString("-NOAUTH Authentication required.\r\n")
+		case command == "PING":
+			w.WriteString("+PONG\r\n")
+		case command == "SELECT":
+			w.WriteString("+OK\r\n")
+		case command == "GET" && len(args) == 2:
+			w.WriteString(s.get(args[1]))
+		case command == "MGET":
+			fmt.Fprintf(w, "*%v\r\n", len(args)-1)
+			for _, key := range args[1:] {
+				w.WriteString(s.get(key))
+			}
+		default:
+			if reply, exists := s.getReply(command + " " + strings.ToUpper(args[1])); len(args) > 1 && exists {
+				w.WriteString(reply)
+			} else if reply, exists := s.getReply(command); exists {
+				w.WriteString(reply)
+			} else {
+				fmt.Fprintf(w, "-ERR unknown command '%v'\r\n", args[0])
+			}
+		}
+	}
+}
+
+// useFakeRedisServer points the scaler to the server with a new connection,
+// the environment is restored when the test is done
+func useFakeRedisServer(tb testing.TB, s *fakeRedisServer, env map[string]string) {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	env[keyRedisHost] = host
+	env[keyRedisPort] = port
+
+	setTestEnv(tb, env)
+
+	resetRedisClient()
+	tb.Cleanup(resetRedisClient)
+}
+
+func resetRedisClient() {
+	rdbMutex.Lock()
+	defer rdbMutex.Unlock()
+
+	if rdb != nil {
+		rdb.Close()
+	}
+
+	rdb = nil
+	rdbFailures = 0
+	rdbNextAttempt = time.Time{}
+}
+
+// BenchmarkRedisValues compares reading the metrics with a GET per key to
+// reading them with getValuesFromRedisServer
+func BenchmarkRedisValues(b *testing.B) {
+	server := newFakeRedisServer(b, nil, "")
+	useFakeRedisServer(b, server, map[string]string{})
+
+	keys := []string{}
+	for _, metricName := range []string{keyScaleMetricBytesIn, keyScaleMetricBytesOut, keyScaleMetricNumRequestsIn, keyScaleMetricNumRequestsOut, keyScaleMetricNumRequestsMisc} {
+		key := "benchmark:" + metricName
+		server.set(key, "1024")
+		keys = append(keys, key)
+	}
+
+	ctx := context.Background()
+	if _, err := getRedisClient(ctx); err != nil {
+		b.Fatal("could not connect with the fake Redis server")
+	}
+
+	run := func(b *testing.B, get func() bool) {
+		roundTrips := atomic.LoadInt64(&server.roundTrips)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if !get() {
+				b.Fatal("get failed")
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(atomic.LoadInt64(&server.roundTrips)-roundTrips)/float64(b.N), "round-trips/op")
+	}
+
+	b.Run("sequential GET", func(b *testing.B) {
+		run(b, func() bool {
+			for _, key := range keys {
+				if _, err := getValueFromRedisServer(ctx, key); err != nil {
+					return false
+				}
+			}
+			return true
+		})
+	})
+
+	b.Run("MGET", func(b *testing.B) {
+		run(b, func() bool {
+			values, err := getValuesFromRedisServer(ctx, keys)
+			return err == nil && len(values) == len(keys)
+		})
+	})
+}
+
+// testCertificates are a CA with a server and a client certificate signed by it
+type testCertificates struct {
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverCert tls.Certificate
+	caPool     *x509.CertPool
+}
+
+func newTestCertificate(tb testing.TB, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return cert, key,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func writeTestFile(tb testing.TB, dir, name string, content []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		tb.Fatal(err)
+	}
+
+	return path
+}
+
+func newTestCertificates(tb testing.TB) testCertificates {
+	dir := tb.TempDir()
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(time.Hour)
+
+	ca, caKey, caPem, _ := newTestCertificate(tb, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+
+	_, _, serverPem, serverKeyPem := newTestCertificate(tb, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "redis"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+
+	_, _, clientPem, clientKeyPem := newTestCertificate(tb, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "scaler"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	serverCert, err := tls.X509KeyPair(serverPem, serverKeyPem)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca)
+
+	return testCertificates{
+		caFile:     writeTestFile(tb, dir, "ca.pem", caPem),
+		certFile:   writeTestFile(tb, dir, "client.pem", clientPem),
+		keyFile:    writeTestFile(tb, dir, "client-key.pem", clientKeyPem),
+		serverCert: serverCert,
+		caPool:     caPool,
+	}
+}
+
+func setTestEnv(tb testing.TB, env map[string]string) {
+	for key, value := range env {
+		oldValue, exists := os.LookupEnv(key)
+		os.Setenv(key, value)
+		tb.Cleanup(func(key string) func() {
+			return func() {
+				if exists {
+					os.Setenv(key, oldValue)
+				} else {
+					os.Unsetenv(key)
+				}
+			}
+		}(key))
+	}
+}
+
+func TestGetRedisPassword(t *testing.T) {
+	setTestEnv(t, map[string]string{keyRedisPassword: "from-env", keyRedisPasswordFile: ""})
+	if password, err := getRedisPassword(); err != nil || password != "from-env" {
+		t.Errorf("got %q [%v], want %q", password, err, "from-env")
+	}
+
+	passwordFile := writeTestFile(t, t.TempDir(), "password", []byte("from file\r\n"))
+	setTestEnv(t, map[string]string{keyRedisPasswordFile: passwordFile})
+	if password, err := getRedisPassword(); err != nil || password != "from file" {
+		t.Errorf("got %q [%v], want %q", password, err, "from file")
+	}
+
+	setTestEnv(t, map[string]string{keyRedisPasswordFile: passwordFile + ".missing"})
+	if _, err := getRedisPassword(); err == nil {
+		t.Error("got no error for a missing password file")
+	}
+}
+
+func TestGetRedisTlsConfig(t *testing.T) {
+	certificates := newTestCertificates(t)
+
+	setTestEnv(t, map[string]string{keyRedisTls: "false"})
+	if tlsConfig, err := getRedisTlsConfig(); tlsConfig != nil || err != nil {
+		t.Errorf("got %v [%v], want no TLS", tlsConfig, err)
+	}
+
+	setTestEnv(t, map[string]string{
+		keyRedisTls:           "true",
+		keyRedisTlsCaFile:     certificates.caFile,
+		keyRedisTlsCertFile:   certificates.certFile,
+		keyRedisTlsKeyFile:    certificates.keyFile,
+		keyRedisTlsServerName: "redis.local",
+	})
+	tlsConfig, err := getRedisTlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 1 || tlsConfig.ServerName != "redis.local" || tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("got %+v, want the CA bundle, the client certificate and the server name", tlsConfig)
+	}
+
+	setTestEnv(t, map[string]string{keyRedisTlsCaFile: certificates.keyFile})
+	if _, err := getRedisTlsConfig(); err == nil {
+		t.Error("got no error for a CA bundle without certificates")
+	}
+
+	setTestEnv(t, map[string]string{keyRedisTlsCaFile: certificates.caFile, keyRedisTlsKeyFile: ""})
+	if _, err := getRedisTlsConfig(); err == nil {
+		t.Error("got no error for a client certificate without its key")
+	}
+}
+
+// TestRedisTlsConnection connects with a TLS Redis stand-in that requires a
+// client certificate and a password
+func TestRedisTlsConnection(t *testing.T) {
+	certificates := newTestCertificates(t)
+	server := newFakeRedisServer(t, &tls.Config{
+		Certificates: []tls.Certificate{certificates.serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    certificates.caPool,
+		MinVersion:   tls.VersionTLS12,
+	}, "secret")
+	server.set("tls:key", "42")
+
+	passwordFile := writeTestFile(t, t.TempDir(), "password", []byte("secret\n"))
+	useFakeRedisServer(t, server, map[string]string{
+		keyRedisTls:           "true",
+		keyRedisTlsCaFile:     certificates.caFile,
+		keyRedisTlsCertFile:   certificates.certFile,
+		keyRedisTlsKeyFile:    certificates.keyFile,
+		keyRedisTlsServerName: "",
+		keyRedisPasswordFile:  passwordFile,
+	})
+
+	if value, err := getValueFromRedisServer(context.Background(), "tls:key"); err != nil || value != "42" {
+		t.Errorf("got %q [%v], want %q", value, err, "42")
+	}
+
+	// without the client certificate, the handshake fails
+	useFakeRedisServer(t, server, map[string]string{
+		keyRedisTlsCertFile: "",
+		keyRedisTlsKeyFile:  "",
+	})
+	if _, err := getRedisClient(context.Background()); err == nil {
+		t.Error("connected without a client certificate")
+	}
+
+	// with a wrong password, the connection fails
+	useFakeRedisServer(t, server, map[string]string{
+		keyRedisTlsCertFile:  certificates.certFile,
+		keyRedisTlsKeyFile:   certificates.keyFile,
+		keyRedisPasswordFile: writeTestFile(t, t.TempDir(), "password", []byte("wrong\n")),
+	})
+	if _, err := getRedisClient(context.Background()); err == nil {
+		t.Error("connected with a wrong password")
+	}
+}
+
+// TestRedisSingleConnectionAttempt makes concurrent calls while connecting,
+// only a single attempt must reach the server
+func TestRedisSingleConnectionAttempt(t *testing.T) {
+	server := newFakeRedisServer(t, nil, "")
+	useFakeRedisServer(t, server, map[string]string{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := getRedisClient(context.Background()); err != nil {
+				t.Error("could not connect with the fake Redis server")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if commands := atomic.LoadInt64(&server.commands); commands != 1 {
+		t.Errorf("got %v commands, want a single PING", commands)
+	}
+}
+
+// TestRedisClientContext connects with a server that never replies, a caller
+// must get its context error without waiting for the connection attempt
+func TestRedisClientContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	setTestEnv(t, map[string]string{
+		keyRedisHost:               host,
+		keyRedisPort:               port,
+		keyRedisReadTimeoutSeconds: "1",
+	})
+	resetRedisClient()
+	defer resetRedisClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := getRedisClient(ctx); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, codes.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("waited %v for the connection attempt", elapsed)
+	}
+
+	// the attempt itself is bounded by the read timeout
+	if _, err := getRedisClient(context.Background()); status.Code(err) != codes.Unavailable {
+		t.Errorf("got error %v, want %v", err, codes.Unavailable)
+	}
+}
+
+func TestEventWindowMembers(t *testing.T) {
+	s := newFakeRedisServer(t, nil, "")
+	useFakeRedisServer(t, s, map[string]string{})
+
+	tests := []struct {
+		members []string
+		value   int64
+		code    codes.Code
+	}{
+		{[]string{"req-1:1024", "req-2:1024", "req:3:48"}, 2096, codes.OK},
+		{[]string{"req-1:1024", "req-2:-48"}, -1, codes.InvalidArgument},
+		{[]string{"req-1:9223372036854775807", "req-2:0"}, 9223372036854775807, codes.OK},
+		{[]string{"req-1:9223372036854775807", "req-2:1"}, -1, codes.InvalidArgument},
+		{[]string{}, 0, codes.OK},
+		{[]string{"1024"}, -1, codes.InvalidArgument},
+		{[]string{":1024"}, -1, codes.InvalidArgument},
+		{[]string{"req-1:abc"}, -1, codes.InvalidArgument},
+	}
+
+	for _, test := range tests {
+		s.reply("ZRANGEBYSCORE", respArray(test.members...))
+
+		value, err := getEventWindowFromRedisServer(context.Background(), "events", metricTypeZSum, 0, 100, false)
+		if status.Code(err) != test.code || value != test.value {
+			t.Errorf("%v: got %v [%v], want %v [%v]", test.members, value, err, test.value, test.code)
+		}
+	}
+
+	s.reply("ZCOUNT", ":3\r\n")
+	if value, err := getEventWindowFromRedisServer(context.Background(), "events", metricTypeZCount, 0, 100, false); err != nil || value != 3 {
+		t.Errorf("got %v [%v], want 3", value, err)
+	}
+}
+
+// respGroup returns the RESP reply of a group of XINFO GROUPS. The fields
+// entries-read and lag of Redis 7 are omitted when empty, nil when "nil".
+func respGroup(name string, pending int64, lastDeliveredID, entriesRead, lag string) string {
+	n := 6
+	fields := fmt.Sprintf("$4\r\nname\r\n$%v\r\n%v\r\n", len(name), name) +
+		fmt.Sprintf("$7\r\npending\r\n:%v\r\n", pending) +
+		fmt.Sprintf("$17\r\nlast-delivered-id\r\n$%v\r\n%v\r\n", len(lastDeliveredID), lastDeliveredID)
+	for _, field := range []struct{ name, value string }{{"entries-read", entriesRead}, {"lag", lag}} {
+		switch field.value {
+		case "":
+			continue
+		case "nil":
+			fields += fmt.Sprintf("$%v\r\n%v\r\n$-1\r\n", len(field.name), field.name)
+		default:
+			fields += fmt.Sprintf("$%v\r\n%v\r\n:%v\r\n", len(field.name), field.name, field.value)
+		}
+		n += 2
+	}
+
+	return fmt.Sprintf("*%v\r\n", n) + fields
+}
+
+func TestConsumerGroupLag(t *testing.T) {
+	s := newFakeRedisServer(t, nil, "")
+	useFakeRedisServer(t, s, map[string]string{})
+
+	entry := "*2\r\n$3\r\n1-1\r\n" + respArray("job", "1")
+	s.reply("XRANGE", "*3\r\n"+strings.Repeat(entry, 3))
+	s.reply("XLEN", ":4\r\n")
+	s.reply("XINFO STREAM", "*4\r\n$6\r\nlength\r\n:8\r\n$13\r\nentries-added\r\n:14\r\n")
+
+	tests := []struct {
+		groups string
+		value  int64
+		code   codes.Code
+	}{
+		// Redis 7 cannot determine the lag, it is estimated
+		{"*1\r\n" + respGroup("workers", 2, "1-0", "10", "nil"), 6, codes.OK},
+		{"*1\r\n" + respGroup("workers", 2, "1-0", "0", "nil"), 10, codes.OK},
+		{"*2\r\n" + respGroup("others", 9, "1-0", "1", "9") + respGroup("workers", 2, "1-0", "10", "5"), 7, codes.OK},
+		{"*1\r\n" + respGroup("workers", 2, "1-0", "nil", "nil"), 5, codes.OK},
+		{"*1\r\n" + respGroup("workers", 2, "1-0", "", ""), 5, codes.OK},
+		{"*1\r\n" + respGroup("workers", 0, "0-0", "", ""), 4, codes.OK},
+		{"*1\r\n" + respGroup("others", 2, "1-0", "10", "5"), -1, codes.InvalidArgument},
+		{"-ERR no such key\r\n", -1, codes.InvalidArgument},
+	}
+
+	for i, test := range tests {
+		s.reply("XINFO", test.groups)
+
+		value, err := getQueueLengthFromRedisServer(context.Background(), "jobs", metricTypeXPending, "workers")
+		if status.Code(err) != test.code || value != test.value {
+			t.Errorf("%v: got %v [%v], want %v [%v]", i, value, err, test.value, test.code)
+		}
+	}
+}
+
+func TestGetNextStreamID(t *testing.T) {
+	tests := []struct {
+		id   string
+		next string
+	}{
+		{"1700000000000-0", "1700000000000-1"},
+		{"5-18446744073709551615", "6-0"},
+		{"18446744073709551615-18446744073709551615", ""},
+		{"invalid", ""},
+	}
+
+	for _, test := range tests {
+		next, err := getNextStreamID(test.id)
+		if next != test.next || (err == nil) != (test.next != "") {
+			t.Errorf("%v: got %v [%v], want %v", test.id, next, err, test.next)
+		}
+	}
+}
+
+// TestConsumerGroupLagLimit checks that the entries of a stream are counted up
+// to redisStreamMaxCount before Redis 7
+func TestConsumerGroupLagLimit(t *testing.T) {
+	s := newFakeRedisServer(t, nil, "")
+	useFakeRedisServer(t, s, map[string]string{})
+
+	entry := "*2\r\n$3\r\n1-1\r\n" + respArray("job", "1")
+	s.reply("XRANGE", fmt.Sprintf("*%v\r\n", redisStreamRangeCount)+strings.Repeat(entry, redisStreamRangeCount))
+	s.reply("XINFO", "*1\r\n"+respGroup("workers", 2, "1-0", "", ""))
+
+	// connect before counting the commands
+	if _, err := getRedisClient(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := atomic.LoadInt64(&s.commands)
+	value, err := getQueueLengthFromRedisServer(context.Background(), "jobs", metricTypeXPending, "workers")
+	if err != nil || value != redisStreamMaxCount+2 {
+		t.Errorf("got %v [%v], want %v", value, err, redisStreamMaxCount+2)
+	}
+
+	if commands = atomic.LoadInt64(&s.commands) - commands; commands != 1+redisStreamMaxCount/redisStreamRangeCount {
+		t.Errorf("got %v commands, want %v", commands, 1+redisStreamMaxCount/redisStreamRangeCount)
+	}
+}
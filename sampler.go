@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/iamazeem/cwm-keda-external-scaler/externalscaler"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	sampler = newMetricSampler()
+)
+
+type sampledObject struct {
+	scaledObjectRef *pb.ScaledObjectRef
+	lastSeen        time.Time
+}
+
+// metricSampler polls the metrics of the ScaledObjects seen by the scaler into
+// the cache at a fixed interval, independently of KEDA's pollingInterval.
+// A ScaledObject that has not been queried for the idle timeout is no longer
+// tracked and its cached values are dropped.
+type metricSampler struct {
+	mutex   sync.Mutex
+	objects map[metricCacheKey]*sampledObject
+}
+
+func newMetricSampler() *metricSampler {
+	return &metricSampler{
+		objects: make(map[metricCacheKey]*sampledObject),
+	}
+}
+
+func getDurationSecondsFromEnv(key, defaultValue string) time.Duration {
+	valueStr := getEnv(key, defaultValue)
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value <= 0 {
+		value, _ = strconv.Atoi(defaultValue)
+		log.Warnf("invalid %v: %v. using default: %v", key, valueStr, value)
+	}
+
+	return time.Duration(value) * time.Second
+}
+
+// track registers the metrics of a ScaledObject for sampling and refreshes
+// their last seen time.
+func (s *metricSampler) track(ctx context.Context, scaledObjectRef *pb.ScaledObjectRef) {
+	scaleMetricNames, err := getScaleMetricNames(scaledObjectRef.ScalerMetadata)
+	if err != nil {
+		log.Errorf("[%v/%v] tracking failed [%v]", scaledObjectRef.Namespace, scaledObjectRef.Name, err.Error())
+		return
+	}
+
+	for _, scaleMetricName := range scaleMetricNames {
+		s.trackMetric(ctx, newMetricCacheKey(scaledObjectRef, scaleMetricName), scaledObjectRef)
+	}
+}
+
+// trackMetric registers a single metric of a ScaledObject. The first time a
+// metric is seen, it is sampled right away and it is only registered once that
+// sample succeeds, otherwise the next call tries again.
+func (s *metricSampler) trackMetric(ctx context.Context, key metricCacheKey, scaledObjectRef *pb.ScaledObjectRef) {
+	if s.refresh(key, scaledObjectRef) {
+		return
+	}
+
+	if !s.sample(ctx, key, scaledObjectRef) {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// a concurrent first call may have registered it in the meantime
+	if object, exists := s.objects[key]; exists {
+		object.scaledObjectRef = scaledObjectRef
+		object.lastSeen = time.Now().UTC()
+		return
+	}
+
+	s.objects[key] = &sampledObject{
+		scaledObjectRef: scaledObjectRef,
+		lastSeen:        time.Now().UTC(),
+	}
+
+	log.Infof("[%v] sampling started", key)
+}
+
+// refresh updates a registered metric, it returns false if it is not registered
+func (s *metricSampler) refresh(key metricCacheKey, scaledObjectRef *pb.ScaledObjectRef) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	object, exists := s.objects[key]
+	if exists {
+		object.scaledObjectRef = scaledObjectRef
+		object.lastSeen = time.Now().UTC()
+	}
+
+	return exists
+}
+
+// sample appends the current value of a metric to the cache, it returns
+// whether the value could be read
+func (s *metricSampler) sample(ctx context.Context, key metricCacheKey, scaledObjectRef *pb.ScaledObjectRef) bool {
+	metadata := scaledObjectRef.ScalerMetadata
+
+	scalePeriodSeconds, err := getScalePeriodSeconds(metadata)
+	if err != nil {
+		log.Errorf("[%v] sampling failed [%v]", key, err.Error())
+		return false
+	}
+
+	forecastHorizonSeconds, err := getForecastHorizonSeconds(metadata)
+	if err != nil {
+		log.Errorf("[%v] sampling failed [%v]", key, err.Error())
+		return false
+	}
+
+	metricType, err := getMetricType(metadata)
+	if err != nil {
+		log.Errorf("[%v] sampling failed [%v]", key, err.Error())
+		return false
+	}
+
+	metric, err := getMetric(ctx, metadata, key.metricName)
+	if err != nil {
+		log.Errorf("[%v] sampling failed [%v]", key, err.Error())
+		return false
+	}
+
+	cache.append(key, metric, scalePeriodSeconds)
+
+	// the longer history is only retained for the series with forecasting
+	if forecastHorizonSeconds > 0 && metricType == metricTypeCounter {
+		forecaster.observe(key, metric.value, time.Now().UTC())
+	} else {
+		forecaster.remove(key)
+	}
+
+	return true
+}
+
+// sampleAll samples all the tracked ScaledObjects and drops the idle ones
+func (s *metricSampler) sampleAll(idleTimeout time.Duration) {
+	now := time.Now().UTC()
+
+	s.mutex.Lock()
+	objects := make(map[metricCacheKey]*pb.ScaledObjectRef, len(s.objects))
+	for key, object := range s.objects {
+		if now.Sub(object.lastSeen) > idleTimeout {
+			delete(s.objects, key)
+			cache.remove(key)
+			smoother.remove(key)
+			forecaster.remove(key)
+			fallback.remove(key)
+			log.Infof("[%v] sampling stopped, not queried since %v", key, object.lastSeen)
+			continue
+		}
+		objects[key] = object.scaledObjectRef
+	}
+	s.mutex.Unlock()
+
+	log.Debugf("sampling %v ScaledObject(s)", len(objects))
+
+	for key, scaledObjectRef := range objects {
+		s.sample(context.Background(), key, scaledObjectRef)
+	}
+}
+
+func (s *metricSampler) run() {
+	interval := getDurationSecondsFromEnv(keySampleIntervalSeconds, defaultSampleIntervalSeconds)
+	idleTimeout := getDurationSecondsFromEnv(keySampleIdleTimeoutSeconds, defaultSampleIdleTimeoutSeconds)
+
+	log.Infof("sampler started [interval: %v, idle timeout: %v]", interval, idleTimeout)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.sampleAll(idleTimeout)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	smoother = newMetricSmoother()
+)
+
+type smoothingState struct {
+	smoothing string
+	timestamp time.Time
+	level     float64
+	trend     float64 // per second, used by holt only
+}
+
+// metricSmoother keeps the per-series state of the smoothing stage applied on
+// top of the window value reported by GetMetrics. The smoothing factors are
+// derived from half-lives so that irregular GetMetrics intervals are weighted
+// by the time elapsed between them.
+type metricSmoother struct {
+	mutex  sync.Mutex
+	states map[metricCacheKey]*smoothingState
+}
+
+func newMetricSmoother() *metricSmoother {
+	return &metricSmoother{
+		states: make(map[metricCacheKey]*smoothingState),
+	}
+}
+
+// getSmoothingFactor returns the weight of a new value observed after elapsed,
+// an observation one half-life old has half of the weight in the average
+func getSmoothingFactor(elapsed, halfLife time.Duration) float64 {
+	return 1 - math.Exp(-math.Ln2*elapsed.Seconds()/halfLife.Seconds())
+}
+
+func (s *metricSmoother) smooth(key metricCacheKey, smoothing string, halfLife, trendHalfLife time.Duration, value int64, now time.Time) int64 {
+	if smoothing == smoothingNone {
+		return value
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	x := float64(value)
+
+	state, exists := s.states[key]
+	if !exists || state.smoothing != smoothing {
+		s.states[key] = &smoothingState{
+			smoothing: smoothing,
+			timestamp: now,
+			level:     x,
+		}
+		log.Debugf("[%v] %v smoothing initialized with %v", key, smoothing, value)
+		return value
+	}
+
+	elapsed := now.Sub(state.timestamp)
+	if elapsed <= 0 {
+		return int64(math.Round(math.Max(state.level, 0)))
+	}
+
+	alpha := getSmoothingFactor(elapsed, halfLife)
+
+	switch smoothing {
+	case smoothingEwma:
+		state.level = alpha*x + (1-alpha)*state.level
+	case smoothingHolt:
+		beta := getSmoothingFactor(elapsed, trendHalfLife)
+		predicted := state.level + state.trend*elapsed.Seconds()
+		level := alpha*x + (1-alpha)*predicted
+		state.trend = beta*(level-state.level)/elapsed.Seconds() + (1-beta)*state.trend
+		state.level = level
+	}
+
+	state.timestamp = now
+
+	smoothed := int64(math.Round(math.Max(state.level, 0)))
+	log.Debugf("[%v] %v smoothing: %v => %v [trend: %v/s]", key, smoothing, value, smoothed, state.trend)
+
+	return smoothed
+}
+
+func (s *metricSmoother) remove(key metricCacheKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.states, key)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestSmoothEwmaStep checks that a step input converges with the configured
+// half-life, regardless of the intervals between the values
+func TestSmoothEwmaStep(t *testing.T) {
+	s := newMetricSmoother()
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	halfLife := time.Minute
+
+	tests := []struct {
+		name      string
+		intervals []time.Duration
+		value     int64
+	}{
+		{"one half-life", []time.Duration{time.Minute}, 500},
+		{"one half-life in steps", []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second}, 500},
+		{"two half-lives", []time.Duration{time.Minute, time.Minute}, 750},
+		{"ten half-lives", []time.Duration{5 * time.Minute, 5 * time.Minute}, 999},
+	}
+
+	for _, test := range tests {
+		key := newTestCacheKey("smoother-" + test.name)
+		if value := s.smooth(key, smoothingEwma, halfLife, halfLife, 0, start); value != 0 {
+			t.Fatalf("%v: got %v initially, want 0", test.name, value)
+		}
+
+		now := start
+		var value int64
+		for _, interval := range test.intervals {
+			now = now.Add(interval)
+			value = s.smooth(key, smoothingEwma, halfLife, halfLife, 1000, now)
+		}
+
+		if value != test.value {
+			t.Errorf("%v: got %v, want %v", test.name, value, test.value)
+		}
+	}
+}
+
+func TestSmoothHoltStep(t *testing.T) {
+	s := newMetricSmoother()
+	key := newTestCacheKey("smoother-holt")
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	halfLife := time.Minute
+	trendHalfLife := 5 * time.Minute
+
+	s.smooth(key, smoothingHolt, halfLife, trendHalfLife, 0, start)
+
+	// the level follows the step faster than a half-life would alone as the
+	// trend picks it up, and settles on it
+	var value int64
+	for now := start.Add(10 * time.Second); now.Sub(start) <= time.Minute; now = now.Add(10 * time.Second) {
+		value = s.smooth(key, smoothingHolt, halfLife, trendHalfLife, 1000, now)
+	}
+	if value <= 500 || value >= 1000 {
+		t.Errorf("got %v after one half-life, want within (500, 1000)", value)
+	}
+
+	for now := start.Add(70 * time.Second); now.Sub(start) <= 2*time.Hour; now = now.Add(10 * time.Second) {
+		value = s.smooth(key, smoothingHolt, halfLife, trendHalfLife, 1000, now)
+	}
+	if math.Abs(float64(value)-1000) > 10 {
+		t.Errorf("got %v after two hours, want 1000", value)
+	}
+	if trend := s.states[key].trend; math.Abs(trend) > 0.1 {
+		t.Errorf("got trend %v/s after two hours, want 0", trend)
+	}
+}
+
+// TestSmoothHoltRamp checks that holt follows a ramp without the lag of ewma
+func TestSmoothHoltRamp(t *testing.T) {
+	s := newMetricSmoother()
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	halfLife := time.Minute
+
+	values := map[string]int64{}
+	for _, smoothing := range []string{smoothingEwma, smoothingHolt} {
+		key := newTestCacheKey("smoother-ramp-" + smoothing)
+		for i := 0; i <= 360; i++ {
+			values[smoothing] = s.smooth(key, smoothing, halfLife, 5*time.Minute, int64(10*i), start.Add(time.Duration(i)*10*time.Second))
+		}
+	}
+
+	// the ramp is at 3600 increasing by 1/s
+	if lag := 3600 - values[smoothingEwma]; lag < 60 {
+		t.Errorf("got ewma %v, want a lag of about a half-life", values[smoothingEwma])
+	}
+	if lag := 3600 - values[smoothingHolt]; lag < -10 || lag > 10 {
+		t.Errorf("got holt %v, want 3600", values[smoothingHolt])
+	}
+}
+
+func TestSmoothReset(t *testing.T) {
+	s := newMetricSmoother()
+	key := newTestCacheKey("smoother-reset")
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if value := s.smooth(key, smoothingNone, time.Minute, time.Minute, 42, start); value != 42 {
+		t.Errorf("got %v without smoothing, want 42", value)
+	}
+	if _, exists := s.states[key]; exists {
+		t.Error("state kept without smoothing")
+	}
+
+	s.smooth(key, smoothingEwma, time.Minute, time.Minute, 0, start)
+	s.smooth(key, smoothingEwma, time.Minute, time.Minute, 1000, start.Add(time.Minute))
+
+	// the same timestamp repeats the level
+	if value := s.smooth(key, smoothingEwma, time.Minute, time.Minute, 0, start.Add(time.Minute)); value != 500 {
+		t.Errorf("got %v for the same timestamp, want 500", value)
+	}
+
+	// switching the smoothing starts over with the new value
+	if value := s.smooth(key, smoothingHolt, time.Minute, time.Minute, 2000, start.Add(2*time.Minute)); value != 2000 {
+		t.Errorf("got %v after switching to %v, want 2000", value, smoothingHolt)
+	}
+	if state := s.states[key]; state.smoothing != smoothingHolt || state.trend != 0 || state.level != 2000 {
+		t.Errorf("got state %+v after switching to %v, want a new one", state, smoothingHolt)
+	}
+
+	if value := s.smooth(key, smoothingEwma, time.Minute, time.Minute, 3000, start.Add(3*time.Minute)); value != 3000 {
+		t.Errorf("got %v after switching back to %v, want 3000", value, smoothingEwma)
+	}
+
+	s.remove(key)
+	if _, exists := s.states[key]; exists {
+		t.Error("state not removed")
+	}
+}
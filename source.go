@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// metricSource is a backend that the metrics and the last activity of a
+// deployment are read from
+type metricSource interface {
+	// getMetricValue returns the current value of the scale metric
+	getMetricValue(ctx context.Context, metadata map[string]string, scaleMetricName string) (int64, error)
+
+	// getLastUpdateTime returns the time of the last activity
+	getLastUpdateTime(ctx context.Context, metadata map[string]string) (time.Time, error)
+}
+
+// metricSources maps the source names to their implementations
+var metricSources = map[string]metricSource{
+	sourceRedis:      redisSource{},
+	sourcePrometheus: prometheusSource{},
+	sourceWebhook:    webhookSource{},
+	sourceHttp:       httpSource{},
+}
+
+// getMetricSource returns the source selected by the source metadata, or by
+// the METRICS_SOURCE environment variable if it is not set
+func getMetricSource(metadata map[string]string) (metricSource, error) {
+	sourceName := getValueFromScalerMetadata(metadata, keySource, getEnv(keyMetricsSource, defaultMetricsSource))
+	if source, exists := metricSources[sourceName]; exists {
+		return source, nil
+	}
+
+	return nil, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keySource, sourceName)
+}
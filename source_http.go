@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// httpSource reads the metrics from an HTTP endpoint returning JSON. The URL
+// and the selectors are templated with {deploymentid} and {metric} like the
+// Redis keys.
+type httpSource struct{}
+
+// getHttpHeaders parses "Name: value" lines, a header value may contain
+// commas but never a line break
+func getHttpHeaders(metadata map[string]string) (http.Header, error) {
+	headers := http.Header{}
+
+	headersStr := getValueFromScalerMetadata(metadata, keyHttpHeaders, "")
+	if headersStr == "" {
+		return headers, nil
+	}
+
+	for _, header := range strings.Split(headersStr, "\n") {
+		if header = strings.TrimSpace(header); header == "" {
+			continue
+		}
+
+		pair := strings.SplitN(header, ":", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %v: %v, must be 'Name: value' lines", keyHttpHeaders, header)
+		}
+		headers.Add(strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1]))
+	}
+
+	return headers, nil
+}
+
+func getHttpTimeoutSeconds(metadata map[string]string) (int64, error) {
+	httpTimeoutSecondsStr := getValueFromScalerMetadata(metadata, keyHttpTimeoutSeconds, defaultHttpTimeoutSeconds)
+	if httpTimeoutSeconds, err := parseInt64(httpTimeoutSecondsStr); err != nil {
+		return -1, err
+	} else if httpTimeoutSeconds <= 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyHttpTimeoutSeconds, httpTimeoutSeconds)
+	} else {
+		return httpTimeoutSeconds, nil
+	}
+}
+
+// getHttpStatusCode returns the code of an HTTP error status, only a server
+// that is not available (5xx, 429) is Unavailable so that onRedisError applies
+func getHttpStatusCode(statusCode int) codes.Code {
+	switch {
+	case statusCode >= 500 || statusCode == http.StatusTooManyRequests:
+		return codes.Unavailable
+	case statusCode == http.StatusNotFound || statusCode == http.StatusGone:
+		return codes.NotFound
+	default:
+		return codes.InvalidArgument
+	}
+}
+
+func expandHttpTemplate(template string, metadata map[string]string, metricName string) string {
+	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
+	return expandKeyTemplate(template, "", deploymentid, metricName)
+}
+
+// fetchHttpDocument returns the decoded JSON document of the endpoint
+func fetchHttpDocument(ctx context.Context, metadata map[string]string, metricName string) (interface{}, error) {
+	urlTemplate := getValueFromScalerMetadata(metadata, keyHttpUrl, "")
+	if urlTemplate == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%v is required for %v: %v", keyHttpUrl, keySource, sourceHttp)
+	}
+
+	headers, err := getHttpHeaders(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	timeoutSeconds, err := getHttpTimeoutSeconds(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// the caller's ctx is kept apart, only its end is reported as is while
+	// timeoutSeconds is an endpoint that is not available
+	requestCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSeconds)*time.Second)
+	defer cancel()
+
+	url := expandHttpTemplate(urlTemplate, metadata, metricName)
+	log.Debugf("requesting HTTP metrics [%v]", url)
+
+	request, err := http.NewRequestWithContext(requestCtx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %v: %v [%v]", keyHttpUrl, url, err.Error())
+	}
+
+	request.Header = headers
+	if request.Header.Get("Accept") == "" {
+		request.Header.Set("Accept", "application/json")
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		if err := getContextError(ctx); err != nil {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.Unavailable, "HTTP request failed: %v [%v]", url, err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, status.Errorf(getHttpStatusCode(response.StatusCode), "HTTP request failed: %v [HTTP %v]", url, response.StatusCode)
+	}
+
+	var document interface{}
+	decoder := json.NewDecoder(io.LimitReader(response.Body, httpMaxResponseBytes))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		if err := getContextError(ctx); err != nil {
+			return nil, err
+		} else if requestCtx.Err() != nil {
+			return nil, status.Errorf(codes.Unavailable, "HTTP request timed out: %v [%v]", url, err.Error())
+		}
+		return nil, status.Errorf(codes.Internal, "invalid JSON response: %v [%v]", url, err.Error())
+	}
+
+	return document, nil
+}
+
+// selectJsonValue returns the value of a JSONPath-like selector e.g.
+// $.data.metrics.bytes_out, items[0].value or $["bytes.out"]
+func selectJsonValue(document interface{}, selector string) (interface{}, error) {
+	value := document
+	path := strings.TrimPrefix(strings.TrimSpace(selector), "$")
+	for path != "" {
+		if strings.HasPrefix(path, "[") {
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing ']' in %v", selector)
+			}
+
+			segment := path[1:end]
+			path = path[end+1:]
+
+			if unquoted, err := strconv.Unquote(segment); err == nil {
+				object, ok := value.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("not an object at [%v]", segment)
+				}
+				if value, ok = object[unquoted]; !ok {
+					return nil, fmt.Errorf("missing key [%v]", segment)
+				}
+				continue
+			}
+
+			index, err := strconv.Atoi(segment)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index [%v]", segment)
+			}
+
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("not an array at [%v]", segment)
+			}
+			if index < 0 {
+				index += len(array)
+			}
+			if index < 0 || index >= len(array) {
+				return nil, fmt.Errorf("index out of range [%v]", segment)
+			}
+			value = array[index]
+			continue
+		}
+
+		path = strings.TrimPrefix(path, ".")
+		end := strings.IndexAny(path, ".[")
+		if end < 0 {
+			end = len(path)
+		}
+
+		key := path[:end]
+		path = path[end:]
+		if key == "" {
+			return nil, fmt.Errorf("empty key in %v", selector)
+		}
+
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("not an object at %v", key)
+		}
+		if value, ok = object[key]; !ok {
+			return nil, fmt.Errorf("missing key %v", key)
+		}
+	}
+
+	return value, nil
+}
+
+// getJsonNumber accepts a JSON number or a numeric string
+func getJsonNumber(value interface{}) (float64, bool) {
+	var number float64
+	var err error
+	switch v := value.(type) {
+	case json.Number:
+		number, err = v.Float64()
+	case string:
+		number, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+	default:
+		return 0, false
+	}
+
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, false
+	}
+
+	return number, true
+}
+
+func (httpSource) getMetricValue(ctx context.Context, metadata map[string]string, scaleMetricName string) (int64, error) {
+	selector := getValueFromScalerMetadata(metadata, keyHttpValueSelector, "")
+	if selector == "" {
+		return -1, status.Errorf(codes.InvalidArgument, "%v is required for %v: %v", keyHttpValueSelector, keySource, sourceHttp)
+	}
+
+	document, err := fetchHttpDocument(ctx, metadata, scaleMetricName)
+	if err != nil {
+		return -1, err
+	}
+
+	selector = expandHttpTemplate(selector, metadata, scaleMetricName)
+	selected, err := selectJsonValue(document, selector)
+	if err != nil {
+		return -1, status.Errorf(codes.NotFound, "invalid %v: %v [%v]", keyHttpValueSelector, selector, err.Error())
+	}
+
+	value, ok := getJsonNumber(selected)
+	if !ok {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v, must be a number", selector, selected)
+	} else if value < 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v, must be positive", selector, value)
+	}
+
+	log.Debugf("got: [%v = %v]", selector, value)
+
+	return int64(math.Round(value)), nil
+}
+
+// getLastUpdateTime accepts a unix timestamp in seconds or an RFC 3339 string
+func (httpSource) getLastUpdateTime(ctx context.Context, metadata map[string]string) (time.Time, error) {
+	selector := getValueFromScalerMetadata(metadata, keyHttpLastActivitySelector, "")
+	if selector == "" {
+		return time.Time{}, status.Errorf(codes.InvalidArgument, "%v is required for %v: %v", keyHttpLastActivitySelector, keySource, sourceHttp)
+	}
+
+	document, err := fetchHttpDocument(ctx, metadata, "")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	selector = expandHttpTemplate(selector, metadata, "")
+	selected, err := selectJsonValue(document, selector)
+	if err != nil {
+		return time.Time{}, status.Errorf(codes.NotFound, "invalid %v: %v [%v]", keyHttpLastActivitySelector, selector, err.Error())
+	}
+
+	var lastUpdateTime time.Time
+	if value, ok := getJsonNumber(selected); ok {
+		seconds, fraction := math.Modf(value)
+		lastUpdateTime = time.Unix(int64(seconds), int64(fraction*float64(time.Second))).UTC()
+	} else if str, ok := selected.(string); ok {
+		if lastUpdateTime, err = time.Parse(time.RFC3339Nano, str); err != nil {
+			return time.Time{}, status.Errorf(codes.InvalidArgument, "invalid last update time: %v => %v [%v]", selector, str, err.Error())
+		}
+		lastUpdateTime = lastUpdateTime.UTC()
+	} else {
+		return time.Time{}, status.Errorf(codes.InvalidArgument, "invalid last update time: %v => %v", selector, selected)
+	}
+
+	log.Debugf("got last update time: %v", lastUpdateTime)
+
+	return lastUpdateTime, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetHttpHeaders(t *testing.T) {
+	headers, err := getHttpHeaders(map[string]string{
+		keyHttpHeaders: "Authorization: Bearer a:b\n\nAccept: application/json, text/plain\r\n",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := headers.Get("Authorization"); got != "Bearer a:b" {
+		t.Errorf("got Authorization %q", got)
+	}
+	if got := headers.Get("Accept"); got != "application/json, text/plain" {
+		t.Errorf("got Accept %q", got)
+	}
+
+	if _, err := getHttpHeaders(map[string]string{keyHttpHeaders: "no colon"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, want %v", err, codes.InvalidArgument)
+	}
+}
+
+func TestHttpSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/minio1":
+			if r.Header.Get("Accept") != "application/json, text/plain" {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			w.Write([]byte(`{"metrics":{"bytes_out":"12.6","a.b":[1,{"v":3}]},"last":"2021-03-01T12:00:00Z","epoch":1614600000.5}`))
+		case "/large":
+			w.Write([]byte(`{"value":"` + strings.Repeat("0", httpMaxResponseBytes) + `1"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	metadata := map[string]string{
+		keyDeploymentId:             "minio1",
+		keyHttpUrl:                  server.URL + "/{deploymentid}",
+		keyHttpHeaders:              "Accept: application/json, text/plain",
+		keyHttpValueSelector:        "$.metrics.{metric}",
+		keyHttpLastActivitySelector: "last",
+	}
+
+	tests := []struct {
+		selector string
+		value    int64
+		code     codes.Code
+	}{
+		{"$.metrics.{metric}", 13, codes.OK},
+		{`metrics["a.b"][-1].v`, 3, codes.OK},
+		{"metrics.missing", -1, codes.NotFound},
+		{"last", -1, codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		metadata[keyHttpValueSelector] = test.selector
+		value, err := (httpSource{}).getMetricValue(context.Background(), metadata, keyScaleMetricBytesOut)
+		if status.Code(err) != test.code || value != test.value {
+			t.Errorf("%v: got %v [%v], want %v [%v]", test.selector, value, err, test.value, test.code)
+		}
+	}
+
+	want := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	if lastUpdateTime, err := (httpSource{}).getLastUpdateTime(context.Background(), metadata); err != nil || !lastUpdateTime.Equal(want) {
+		t.Errorf("got %v [%v], want %v", lastUpdateTime, err, want)
+	}
+
+	metadata[keyHttpLastActivitySelector] = "epoch"
+	want = time.Unix(1614600000, 500000000).UTC()
+	if lastUpdateTime, err := (httpSource{}).getLastUpdateTime(context.Background(), metadata); err != nil || !lastUpdateTime.Equal(want) {
+		t.Errorf("got %v [%v], want %v", lastUpdateTime, err, want)
+	}
+
+	// the response body is limited
+	metadata[keyHttpUrl] = server.URL + "/large"
+	metadata[keyHttpValueSelector] = "value"
+	if _, err := (httpSource{}).getMetricValue(context.Background(), metadata, keyScaleMetricBytesOut); status.Code(err) != codes.Internal {
+		t.Errorf("got error %v, want %v", err, codes.Internal)
+	}
+}
+
+func TestHttpSourceErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		case "/busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>502 Bad Gateway</html>"))
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		code codes.Code
+	}{
+		{"/slow", codes.Unavailable},
+		{"/busy", codes.Unavailable},
+		{"/down", codes.Unavailable},
+		{"/forbidden", codes.InvalidArgument},
+		{"/missing", codes.NotFound},
+	}
+
+	for _, test := range tests {
+		metadata := map[string]string{
+			keyHttpUrl:            server.URL + test.path,
+			keyHttpValueSelector:  "value",
+			keyHttpTimeoutSeconds: "1",
+		}
+
+		if _, err := (httpSource{}).getMetricValue(context.Background(), metadata, keyScaleMetricBytesOut); status.Code(err) != test.code {
+			t.Errorf("%v: got error %v, want %v", test.path, err, test.code)
+		}
+	}
+
+	// the end of the caller's context is reported as is
+	metadata := map[string]string{
+		keyHttpUrl:            server.URL + "/slow",
+		keyHttpValueSelector:  "value",
+		keyHttpTimeoutSeconds: "1",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := (httpSource{}).getMetricValue(ctx, metadata, keyScaleMetricBytesOut); status.Code(err) != codes.Canceled {
+		t.Errorf("got error %v, want %v", err, codes.Canceled)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// prometheusSource evaluates PromQL queries against a Prometheus-compatible
+// /api/v1/query endpoint. The queries are templated with {deploymentid} and
+// {metric} like the Redis keys.
+type prometheusSource struct{}
+
+type prometheusResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+type prometheusVectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"` // [<unix time>, "<value>"]
+}
+
+func expandPrometheusQuery(query string, metadata map[string]string, metricName string) string {
+	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
+	return expandKeyTemplate(query, "", deploymentid, metricName)
+}
+
+// queryPrometheus returns the value of the first sample of a scalar or
+// instant vector query result
+func queryPrometheus(ctx context.Context, metadata map[string]string, query string) (float64, error) {
+	address := getValueFromScalerMetadata(metadata, keyPrometheusServerAddress, getEnv(keyPrometheusAddress, defaultPrometheusAddress))
+	timeout := getDurationSecondsFromEnv(keyPrometheusTimeoutSeconds, defaultPrometheusTimeoutSeconds)
+
+	// the caller's ctx is kept apart, only its end is reported as is while the
+	// timeout of the query is a Prometheus server that is not available
+	requestCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	queryUrl := strings.TrimRight(address, "/") + "/api/v1/query?" + url.Values{"query": {query}}.Encode()
+	log.Debugf("querying Prometheus [%v]", queryUrl)
+
+	request, err := http.NewRequestWithContext(requestCtx, http.MethodGet, queryUrl, nil)
+	if err != nil {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid %v: %v [%v]", keyPrometheusServerAddress, address, err.Error())
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		if err := getContextError(ctx); err != nil {
+			return 0, err
+		}
+		return 0, status.Errorf(codes.Unavailable, "Prometheus query failed: %v [%v]", query, err.Error())
+	}
+	defer response.Body.Close()
+
+	// the errors of Prometheus come with a JSON body, the ones of a proxy in
+	// front of it may not
+	failed := response.StatusCode < 200 || response.StatusCode > 299
+
+	var result prometheusResponse
+	if err := json.NewDecoder(io.LimitReader(response.Body, httpMaxResponseBytes)).Decode(&result); err != nil {
+		if err := getContextError(ctx); err != nil {
+			return 0, err
+		} else if requestCtx.Err() != nil {
+			return 0, status.Errorf(codes.Unavailable, "Prometheus query timed out: %v [%v]", query, err.Error())
+		} else if failed {
+			return 0, status.Errorf(getHttpStatusCode(response.StatusCode), "Prometheus query failed [HTTP %v]: %v", response.StatusCode, query)
+		}
+		return 0, status.Errorf(codes.Internal, "invalid Prometheus response [HTTP %v]: %v [%v]", response.StatusCode, query, err.Error())
+	} else if result.Status != "success" {
+		code := codes.Internal
+		if failed && getHttpStatusCode(response.StatusCode) == codes.Unavailable {
+			code = codes.Unavailable
+		}
+		return 0, status.Errorf(code, "Prometheus query failed [HTTP %v]: %v [%v: %v]", response.StatusCode, query, result.ErrorType, result.Error)
+	}
+
+	var sampleValue []interface{}
+	switch result.Data.ResultType {
+	case "scalar":
+		if err := json.Unmarshal(result.Data.Result, &sampleValue); err != nil {
+			return 0, status.Errorf(codes.Internal, "invalid Prometheus scalar: %v [%v]", query, err.Error())
+		}
+	case "vector":
+		var samples []prometheusVectorSample
+		if err := json.Unmarshal(result.Data.Result, &samples); err != nil {
+			return 0, status.Errorf(codes.Internal, "invalid Prometheus vector: %v [%v]", query, err.Error())
+		} else if len(samples) == 0 {
+			return 0, status.Errorf(codes.NotFound, "empty Prometheus result: %v", query)
+		} else if len(samples) > 1 {
+			log.Warnf("Prometheus query returned %v samples, using the first one: %v", len(samples), query)
+		}
+		sampleValue = samples[0].Value
+	default:
+		return 0, status.Errorf(codes.InvalidArgument, "unsupported Prometheus result type: %v => %v", query, result.Data.ResultType)
+	}
+
+	if len(sampleValue) != 2 {
+		return 0, status.Errorf(codes.Internal, "invalid Prometheus sample: %v => %v", query, sampleValue)
+	}
+
+	valueStr, _ := sampleValue[1].(string)
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, status.Errorf(codes.InvalidArgument, "invalid Prometheus value: %v => %v", query, sampleValue[1])
+	}
+
+	log.Debugf("got: [%v = %v]", query, value)
+
+	return value, nil
+}
+
+func (prometheusSource) getMetricValue(ctx context.Context, metadata map[string]string, scaleMetricName string) (int64, error) {
+	query := getValueFromScalerMetadata(metadata, keyPrometheusQuery, "")
+	if query == "" {
+		return -1, status.Errorf(codes.InvalidArgument, "%v is required for %v: %v", keyPrometheusQuery, keySource, sourcePrometheus)
+	}
+
+	value, err := queryPrometheus(ctx, metadata, expandPrometheusQuery(query, metadata, scaleMetricName))
+	if err != nil {
+		return -1, err
+	} else if value < 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid %v: %v => %v, must be positive", keyPrometheusQuery, query, value)
+	}
+
+	return int64(math.Round(value)), nil
+}
+
+// getLastUpdateTime expects the query to return a unix timestamp in seconds
+// e.g. timestamp(...) or a last activity gauge
+func (prometheusSource) getLastUpdateTime(ctx context.Context, metadata map[string]string) (time.Time, error) {
+	query := getValueFromScalerMetadata(metadata, keyPrometheusLastActivityQuery, "")
+	if query == "" {
+		return time.Time{}, status.Errorf(codes.InvalidArgument, "%v is required for %v: %v", keyPrometheusLastActivityQuery, keySource, sourcePrometheus)
+	}
+
+	value, err := queryPrometheus(ctx, metadata, expandPrometheusQuery(query, metadata, ""))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	seconds, fraction := math.Modf(value)
+	lastUpdateTime := time.Unix(int64(seconds), int64(fraction*float64(time.Second))).UTC()
+
+	log.Debugf("got last update time: %v [%v]", lastUpdateTime, fmt.Sprintf("%f", value))
+
+	return lastUpdateTime, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newPrometheusStandIn serves the given /api/v1/query responses by query
+func newPrometheusStandIn(t *testing.T, responses map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+
+		query := r.URL.Query().Get("query")
+		switch query {
+		case "bad gateway":
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>502 Bad Gateway</html>"))
+			return
+		case "unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status":"error","errorType":"unavailable","error":"too many queries"}`))
+			return
+		case "large":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1614600000,"` + strings.Repeat("0", httpMaxResponseBytes) + `1"]}}`))
+			return
+		}
+
+		response, exists := responses[query]
+		if !exists {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"unknown query"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestQueryPrometheus(t *testing.T) {
+	server := newPrometheusStandIn(t, map[string]string{
+		"scalar":       `{"status":"success","data":{"resultType":"scalar","result":[1614600000,"42.5"]}}`,
+		"vector":       `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"deployment":"minio1"},"value":[1614600000,"7"]},{"metric":{},"value":[1614600000,"9"]}]}}`,
+		"empty":        `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+		"nan":          `{"status":"success","data":{"resultType":"scalar","result":[1614600000,"NaN"]}}`,
+		"matrix":       `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+		"failed":       `{"status":"error","errorType":"execution","error":"query timed out"}`,
+		"invalid json": `{"status":`,
+	})
+
+	tests := []struct {
+		query string
+		value float64
+		code  codes.Code
+	}{
+		{"scalar", 42.5, codes.OK},
+		{"vector", 7, codes.OK},
+		{"empty", 0, codes.NotFound},
+		{"nan", 0, codes.InvalidArgument},
+		{"matrix", 0, codes.InvalidArgument},
+		{"failed", 0, codes.Internal},
+		{"unknown", 0, codes.Internal},
+		{"invalid json", 0, codes.Internal},
+		{"bad gateway", 0, codes.Unavailable},
+		{"unavailable", 0, codes.Unavailable},
+		{"large", 0, codes.Internal},
+	}
+
+	metadata := map[string]string{keyPrometheusServerAddress: server.URL}
+	for _, test := range tests {
+		value, err := queryPrometheus(context.Background(), metadata, test.query)
+		if status.Code(err) != test.code || (err == nil && value != test.value) {
+			t.Errorf("%v: got %v [%v], want %v [%v]", test.query, value, err, test.value, test.code)
+		}
+	}
+
+	// an unreachable server is unavailable
+	server.Close()
+	if _, err := queryPrometheus(context.Background(), metadata, "scalar"); status.Code(err) != codes.Unavailable {
+		t.Errorf("got error %v, want %v", err, codes.Unavailable)
+	}
+}
+
+func TestPrometheusSource(t *testing.T) {
+	server := newPrometheusStandIn(t, map[string]string{
+		`sum(rate(minio_s3_traffic_sent_bytes{deployment="minio1"}[5m]))`: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1614600000,"1023.6"]}]}}`,
+		`last_activity{deployment="minio1"}`:                              `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1614600000,"1614599999.25"]}]}}`,
+	})
+
+	metadata := map[string]string{
+		keyPrometheusServerAddress:     server.URL,
+		keyDeploymentId:                "minio1",
+		keyPrometheusQuery:             `sum(rate(minio_s3_traffic_sent_bytes{deployment="{deploymentid}"}[5m]))`,
+		keyPrometheusLastActivityQuery: `last_activity{deployment="{deploymentid}"}`,
+	}
+
+	if value, err := (prometheusSource{}).getMetricValue(context.Background(), metadata, keyScaleMetricBytesOut); err != nil || value != 1024 {
+		t.Errorf("got %v [%v], want 1024", value, err)
+	}
+
+	want := time.Unix(1614599999, 250000000).UTC()
+	if lastUpdateTime, err := (prometheusSource{}).getLastUpdateTime(context.Background(), metadata); err != nil || !lastUpdateTime.Equal(want) {
+		t.Errorf("got %v [%v], want %v", lastUpdateTime, err, want)
+	}
+
+	delete(metadata, keyPrometheusLastActivityQuery)
+	if _, err := (prometheusSource{}).getLastUpdateTime(context.Background(), metadata); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, want %v", err, codes.InvalidArgument)
+	}
+}
+
+// TestQueryPrometheusTimeout checks that a Prometheus server hanging beyond
+// PROMETHEUS_TIMEOUT_SECONDS is unavailable, so that onRedisError applies,
+// while the end of the caller's context is reported as is
+func TestQueryPrometheusTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	setTestEnv(t, map[string]string{keyPrometheusTimeoutSeconds: "1"})
+	metadata := map[string]string{keyPrometheusServerAddress: server.URL}
+
+	if _, err := queryPrometheus(context.Background(), metadata, "slow"); status.Code(err) != codes.Unavailable {
+		t.Errorf("got error %v, want %v", err, codes.Unavailable)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := queryPrometheus(ctx, metadata, "slow"); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, codes.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// redisSource reads the counters and the last update time written to the
+// Redis server by cwm-worker-logger, with the string or hash storage layout
+type redisSource struct{}
+
+func getRedisLastUpdateValue(ctx context.Context, metadata map[string]string) (string, string, error) {
+	if getMetricsStorage() == metricsStorageHash {
+		metricsHashKey := getMetricsHashKey(metadata)
+		lastUpdateHashField := getEnv(keyLastUpdateHashField, defaultLastUpdateHashField)
+		lastUpdateValue, err := getHashValueFromRedisServer(ctx, metricsHashKey, lastUpdateHashField)
+		return metricsHashKey + " " + lastUpdateHashField, lastUpdateValue, err
+	}
+
+	lastUpdateKey := getLastUpdateKey(metadata)
+	lastUpdateValue, err := getValueFromRedisServer(ctx, lastUpdateKey)
+	return lastUpdateKey, lastUpdateValue, err
+}
+
+func (redisSource) getLastUpdateTime(ctx context.Context, metadata map[string]string) (time.Time, error) {
+	lastUpdateKey, lastUpdateValue, err := getRedisLastUpdateValue(ctx, metadata)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	lastUpdateTime, err := time.Parse(time.RFC3339Nano, lastUpdateValue)
+	if err != nil {
+		return time.Time{}, status.Errorf(codes.Internal, "invalid value: %v => %v", lastUpdateKey, lastUpdateTime)
+	}
+
+	return lastUpdateTime, nil
+}
+
+// getRedisEventWindowValues reads the values of the sorted set of each metric
+// over [now - scalePeriodSeconds, now]
+func getRedisEventWindowValues(ctx context.Context, metadata map[string]string, metricType string, metricNames []string) (map[string]int64, error) {
+	scalePeriodSeconds, err := getScalePeriodSeconds(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	trim, err := getTrimEventWindow(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	now := float64(time.Now().UTC().UnixNano()) / float64(time.Second)
+	min := now - float64(scalePeriodSeconds)
+
+	metricValues := make(map[string]int64, len(metricNames))
+	for _, metricName := range metricNames {
+		key := getMetricKey(metadata, metricName)
+		metricValue, err := getEventWindowFromRedisServer(ctx, key, metricType, min, now, trim)
+		if err != nil {
+			return nil, err
+		}
+		metricValues[metricName] = metricValue
+	}
+
+	return metricValues, nil
+}
+
+// getRedisQueueValues reads the length of the list or stream of each metric
+func getRedisQueueValues(ctx context.Context, metadata map[string]string, metricType string, metricNames []string) (map[string]int64, error) {
+	consumerGroup := getValueFromScalerMetadata(metadata, keyConsumerGroup, "")
+	if metricType == metricTypeXPending && consumerGroup == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "%v is required for %v: %v", keyConsumerGroup, keyMetricType, metricType)
+	}
+
+	metricValues := make(map[string]int64, len(metricNames))
+	for _, metricName := range metricNames {
+		key := getMetricKey(metadata, metricName)
+		metricValue, err := getQueueLengthFromRedisServer(ctx, key, metricType, consumerGroup)
+		if err != nil {
+			return nil, err
+		}
+		metricValues[metricName] = metricValue
+	}
+
+	return metricValues, nil
+}
+
+// getRedisMetricValues reads the values of all the metrics, the counters in a
+// single round trip
+func getRedisMetricValues(ctx context.Context, metadata map[string]string, metricNames []string) (map[string]int64, error) {
+	if metricType, err := getMetricType(metadata); err != nil {
+		return nil, err
+	} else if isQueueMetricType(metricType) {
+		return getRedisQueueValues(ctx, metadata, metricType, metricNames)
+	} else if metricType != metricTypeCounter {
+		return getRedisEventWindowValues(ctx, metadata, metricType, metricNames)
+	}
+
+	keys := make([]string, 0, len(metricNames))
+	for _, metricName := range metricNames {
+		keys = append(keys, getMetricKey(metadata, metricName))
+	}
+
+	metricValues := make(map[string]int64, len(metricNames))
+	if len(keys) == 0 {
+		return metricValues, nil
+	}
+
+	var valueStrs []string
+	var err error
+	if getMetricsStorage() == metricsStorageHash {
+		metricsHashKey := getMetricsHashKey(metadata)
+		if valueStrs, err = getHashValuesFromRedisServer(ctx, metricsHashKey, metricNames); status.Code(err) == codes.NotFound {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %v: %v %v [%v]", keyScaleMetricName, metricsHashKey, metricNames, status.Convert(err).Message())
+		} else if err != nil {
+			return nil, err
+		}
+	} else if valueStrs, err = getValuesFromRedisServer(ctx, keys); status.Code(err) == codes.NotFound {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %v: %v [%v]", keyScaleMetricName, keys, status.Convert(err).Message())
+	} else if err != nil {
+		return nil, err
+	}
+
+	for i, valueStr := range valueStrs {
+		metricValue, err := parseMetricValue(valueStr)
+		if err != nil {
+			return nil, err
+		}
+		metricValues[metricNames[i]] = metricValue
+	}
+
+	return metricValues, nil
+}
+
+// getMetricValue evaluates the expression of the scale metric over the
+// counters it references
+func (redisSource) getMetricValue(ctx context.Context, metadata map[string]string, scaleMetricName string) (int64, error) {
+	expression, err := getScaleMetricExpression(metadata, scaleMetricName)
+	if err != nil {
+		return -1, err
+	}
+
+	values, err := getRedisMetricValues(ctx, metadata, expression.metricNames)
+	if err != nil {
+		return -1, err
+	}
+
+	scaleMetricValue, err := expression.evaluate(values)
+	if err != nil {
+		return -1, status.Errorf(status.Code(err), "could not evaluate %v: %v [%v]", keyScaleMetricExpression, expression, err.Error())
+	} else if scaleMetricValue < 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid %v: %v => %v, must be positive", keyScaleMetricExpression, expression, scaleMetricValue)
+	}
+
+	return scaleMetricValue, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	sourceMemory = "memory"
+)
+
+// memorySource is a metricSource for the tests that keeps the counters and the
+// last update times of the deployments in memory
+type memorySource struct {
+	mutex      sync.Mutex
+	values     map[string]map[string]int64 // map: deploymentid => metric name => value
+	lastUpdate map[string]time.Time        // map: deploymentid => last action
+}
+
+var (
+	memory = newMemorySource()
+)
+
+func init() {
+	metricSources[sourceMemory] = memory
+}
+
+func newMemorySource() *memorySource {
+	return &memorySource{
+		values:     make(map[string]map[string]int64),
+		lastUpdate: make(map[string]time.Time),
+	}
+}
+
+func (s *memorySource) set(deploymentid string, values map[string]int64, lastUpdate time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.values[deploymentid] = values
+	s.lastUpdate[deploymentid] = lastUpdate
+}
+
+func (s *memorySource) add(deploymentid, metricName string, value int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.values[deploymentid] == nil {
+		s.values[deploymentid] = make(map[string]int64)
+	}
+	s.values[deploymentid][metricName] += value
+	s.lastUpdate[deploymentid] = time.Now().UTC()
+}
+
+func (s *memorySource) getMetricValue(_ context.Context, metadata map[string]string, scaleMetricName string) (int64, error) {
+	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
+
+	expression, err := getScaleMetricExpression(metadata, scaleMetricName)
+	if err != nil {
+		return -1, err
+	}
+
+	s.mutex.Lock()
+	values := make(map[string]int64, len(expression.metricNames))
+	for _, metricName := range expression.metricNames {
+		value, exists := s.values[deploymentid][metricName]
+		if !exists {
+			s.mutex.Unlock()
+			return -1, status.Errorf(codes.NotFound, "no value: %v %v", deploymentid, metricName)
+		}
+		values[metricName] = value
+	}
+	s.mutex.Unlock()
+
+	return expression.evaluate(values)
+}
+
+func (s *memorySource) getLastUpdateTime(_ context.Context, metadata map[string]string) (time.Time, error) {
+	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	lastUpdateTime, exists := s.lastUpdate[deploymentid]
+	if !exists {
+		return time.Time{}, status.Errorf(codes.NotFound, "no last update time: %v", deploymentid)
+	}
+
+	return lastUpdateTime, nil
+}
+
+func TestGetMetricFromMemorySource(t *testing.T) {
+	memory.set("source-test", map[string]int64{
+		keyScaleMetricBytesIn:         100,
+		keyScaleMetricBytesOut:        250,
+		keyScaleMetricNumRequestsIn:   3,
+		keyScaleMetricNumRequestsOut:  4,
+		keyScaleMetricNumRequestsMisc: 5,
+	}, time.Now().UTC())
+
+	tests := []struct {
+		scaleMetricName string
+		expression      string
+		value           int64
+		code            codes.Code
+	}{
+		{keyScaleMetricBytesOut, "", 250, codes.OK},
+		{keyScaleMetricBytesTotal, "", 350, codes.OK},
+		{keyScaleMetricNumRequestsTotal, "", 12, codes.OK},
+		{"weighted", "bytes_out + 10 * num_requests_misc", 300, codes.OK},
+		{"unknown", "", -1, codes.NotFound},
+	}
+
+	for _, test := range tests {
+		metadata := map[string]string{
+			keySource:          sourceMemory,
+			keyDeploymentId:    "source-test",
+			keyScaleMetricName: test.scaleMetricName,
+		}
+		if test.expression != "" {
+			metadata[keyScaleMetricExpression] = test.expression
+		}
+
+		m, err := getMetric(context.Background(), metadata, test.scaleMetricName)
+		if status.Code(err) != test.code {
+			t.Errorf("%v: got error %v, want %v", test.scaleMetricName, err, test.code)
+			continue
+		}
+		if err == nil && (m.name != test.scaleMetricName || m.value != test.value) {
+			t.Errorf("%v: got %v, want %v", test.scaleMetricName, m, test.value)
+		}
+	}
+}
+
+func TestGetLastUpdateTimeFromMemorySource(t *testing.T) {
+	lastUpdate := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	memory.set("source-test-last-update", map[string]int64{}, lastUpdate)
+
+	metadata := map[string]string{keySource: sourceMemory, keyDeploymentId: "source-test-last-update"}
+	if got, err := getLastUpdateTime(context.Background(), metadata); err != nil || !got.Equal(lastUpdate) {
+		t.Errorf("got %v [%v], want %v", got, err, lastUpdate)
+	}
+
+	metadata[keyDeploymentId] = "source-test-missing"
+	if _, err := getLastUpdateTime(context.Background(), metadata); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestGetMetricSource(t *testing.T) {
+	if source, err := getMetricSource(map[string]string{keySource: sourceMemory}); err != nil || source != memory {
+		t.Errorf("got %v [%v], want the memory source", source, err)
+	}
+
+	if _, err := getMetricSource(map[string]string{keySource: "unknown"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, want %v", err, codes.InvalidArgument)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// webhookSource reads the metrics accumulated from the MinIO audit log entries
+// received by the built-in webhook server
+type webhookSource struct{}
+
+func (webhookSource) getMetricValue(_ context.Context, metadata map[string]string, scaleMetricName string) (int64, error) {
+	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
+
+	expression, err := getScaleMetricExpression(metadata, scaleMetricName)
+	if err != nil {
+		return -1, err
+	}
+
+	values, ok := webhookStore.getValues(deploymentid, expression.metricNames)
+	if !ok {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid %v: [deploymentid: %v] %v", keyScaleMetricName, deploymentid, expression.metricNames)
+	}
+
+	scaleMetricValue, err := expression.evaluate(values)
+	if err != nil {
+		return -1, status.Errorf(status.Code(err), "could not evaluate %v: %v [%v]", keyScaleMetricExpression, expression, err.Error())
+	} else if scaleMetricValue < 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid %v: %v => %v, must be positive", keyScaleMetricExpression, expression, scaleMetricValue)
+	}
+
+	return scaleMetricValue, nil
+}
+
+func (webhookSource) getLastUpdateTime(_ context.Context, metadata map[string]string) (time.Time, error) {
+	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
+
+	return webhookStore.getLastUpdateTime(deploymentid), nil
+}
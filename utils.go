@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"os"
 	"strconv"
 	"strings"
@@ -29,6 +30,40 @@ func getEnv(key, defaultValue string) string {
 	}
 }
 
+// getContextError returns the gRPC status of a cancelled or expired request
+// context, nil if the context is still active
+func getContextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, ctx.Err().Error())
+	default:
+		return nil
+	}
+}
+
+func getBoolFromEnv(key, defaultValue string) bool {
+	valueStr := getEnv(key, defaultValue)
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		value, _ = strconv.ParseBool(defaultValue)
+		log.Warnf("invalid %v: %v. using default: %v", key, valueStr, value)
+	}
+
+	return value
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
 func getValueFromScalerMetadata(metadata map[string]string, key, defaultValue string) string {
 	key = strings.TrimSpace(key)
 	log.Debugf("getting metadata: '%v' [default: %v]", key, defaultValue)
@@ -42,10 +77,40 @@ func getValueFromScalerMetadata(metadata map[string]string, key, defaultValue st
 	}
 }
 
+// expandKeyTemplate replaces the {prefix}, {deploymentid} and {metric}
+// placeholders of a Redis key template
+func expandKeyTemplate(template, prefix, deploymentid, metricName string) string {
+	return strings.NewReplacer(
+		keyTemplatePrefix, prefix,
+		keyTemplateDeploymentId, deploymentid,
+		keyTemplateMetric, metricName,
+	).Replace(template)
+}
+
+func getMetricsStorage() string {
+	metricsStorage := getEnv(keyMetricsStorage, defaultMetricsStorage)
+	switch metricsStorage {
+	case metricsStorageString, metricsStorageHash:
+		return metricsStorage
+	default:
+		log.Warnf("invalid %v: %v. using default: %v", keyMetricsStorage, metricsStorage, defaultMetricsStorage)
+		return defaultMetricsStorage
+	}
+}
+
+// getMetricsHashKey returns the per-deployment hash key of the hash storage
+func getMetricsHashKey(metadata map[string]string) string {
+	metricsPrefix := getEnv(keyMetricsPrefix, defaultMetricsPrefix)
+	metricsHashKeyTemplate := getEnv(keyMetricsHashKeyTemplate, defaultMetricsHashKeyTemplate)
+	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
+	return expandKeyTemplate(metricsHashKeyTemplate, metricsPrefix, deploymentid, "")
+}
+
 func getLastUpdateKey(metadata map[string]string) string {
 	lastUpdatePrefix := getEnv(keyLastUpdatePrefix, defaultLastUpdatePrefix)
+	lastUpdateKeyTemplate := getEnv(keyLastUpdateKeyTemplate, defaultLastUpdateKeyTemplate)
 	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
-	lastUpdateKey := lastUpdatePrefix + ":" + deploymentid
+	lastUpdateKey := expandKeyTemplate(lastUpdateKeyTemplate, lastUpdatePrefix, deploymentid, "")
 	return lastUpdateKey
 }
 
@@ -62,19 +127,14 @@ func getIsActiveTtlSeconds(metadata map[string]string) (int64, error) {
 	}
 }
 
-func getLastUpdateTime(metadata map[string]string) (time.Time, error) {
-	lastUpdateKey := getLastUpdateKey(metadata)
-	lastUpdateValue, isValidLastUpdateValue := getValueFromRedisServer(lastUpdateKey)
-	if !isValidLastUpdateValue {
-		return time.Time{}, status.Errorf(codes.Internal, "invalid value: %v => %v", lastUpdateKey, lastUpdateValue)
-	}
-
-	lastUpdateTime, err := time.Parse(time.RFC3339Nano, lastUpdateValue)
+// getLastUpdateTime returns the time of the last activity from the source
+func getLastUpdateTime(ctx context.Context, metadata map[string]string) (time.Time, error) {
+	source, err := getMetricSource(metadata)
 	if err != nil {
-		return time.Time{}, status.Errorf(codes.Internal, "invalid value: %v => %v", lastUpdateKey, lastUpdateTime)
+		return time.Time{}, err
 	}
 
-	return lastUpdateTime, nil
+	return source.getLastUpdateTime(ctx, metadata)
 }
 
 func getScalePeriodSeconds(metadata map[string]string) (int64, error) {
@@ -88,6 +148,89 @@ func getScalePeriodSeconds(metadata map[string]string) (int64, error) {
 	}
 }
 
+// StreamIsActive utility functions
+
+func getStreamIsActiveIntervalSeconds(metadata map[string]string) (int64, error) {
+	streamIsActiveIntervalSecondsStr := getValueFromScalerMetadata(metadata, keyStreamIsActiveIntervalSeconds, defaultStreamIsActiveIntervalSeconds)
+	if streamIsActiveIntervalSeconds, err := parseInt64(streamIsActiveIntervalSecondsStr); err != nil {
+		return -1, err
+	} else if streamIsActiveIntervalSeconds <= 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyStreamIsActiveIntervalSeconds, streamIsActiveIntervalSeconds)
+	} else {
+		return streamIsActiveIntervalSeconds, nil
+	}
+}
+
+// GetMetricSpec utility functions
+
+func parseTargetValue(targetValueStr string) (int64, error) {
+	targetValue, err := parseInt64(targetValueStr)
+	if err != nil {
+		return -1, status.Errorf(codes.InvalidArgument, "could not get metadata value for %v. %v", keyTargetValue, err.Error())
+	} else if targetValue < 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyTargetValue, targetValue)
+	}
+
+	return targetValue, nil
+}
+
+// getMetricSpecs returns the {metric name, target value} list from scaleMetrics
+// e.g. "bytes_out:1000000,num_requests_in:50", or from scaleMetricName and
+// targetValue if scaleMetrics is not set
+func getMetricSpecs(metadata map[string]string) ([]metric, error) {
+	scaleMetrics := getValueFromScalerMetadata(metadata, keyScaleMetrics, "")
+	if scaleMetrics == "" {
+		scaleMetricName := getValueFromScalerMetadata(metadata, keyScaleMetricName, defaultScaleMetricName)
+		targetValueStr := getValueFromScalerMetadata(metadata, keyTargetValue, defaultTargetValue)
+		targetValue, err := parseTargetValue(targetValueStr)
+		if err != nil {
+			return nil, err
+		}
+
+		return []metric{{scaleMetricName, targetValue}}, nil
+	}
+
+	specs := []metric{}
+	names := map[string]bool{}
+	for _, entry := range strings.Split(scaleMetrics, ",") {
+		fields := strings.Split(entry, ":")
+		if len(fields) != 2 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v [expected: name:target]", keyScaleMetrics, entry)
+		}
+
+		scaleMetricName := strings.TrimSpace(fields[0])
+		if scaleMetricName == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v [empty metric name]", keyScaleMetrics, entry)
+		} else if names[scaleMetricName] {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v [duplicate metric name]", keyScaleMetrics, entry)
+		}
+
+		targetValue, err := parseTargetValue(strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, err
+		}
+
+		names[scaleMetricName] = true
+		specs = append(specs, metric{scaleMetricName, targetValue})
+	}
+
+	return specs, nil
+}
+
+func getScaleMetricNames(metadata map[string]string) ([]string, error) {
+	specs, err := getMetricSpecs(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	scaleMetricNames := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		scaleMetricNames = append(scaleMetricNames, spec.name)
+	}
+
+	return scaleMetricNames, nil
+}
+
 // GetMetrics utility functions
 
 func parseInt64(s string) (int64, error) {
@@ -108,76 +251,179 @@ func parseMetricValue(metricValueStr string) (int64, error) {
 	}
 }
 
-func getMetricValue(metricsPrefix, metricName string) (int64, error) {
-	key := metricsPrefix + ":" + metricName
-	if valueStr, ok := getValueFromRedisServer(key); !ok {
-		return -1, status.Errorf(codes.InvalidArgument, "invalid %v: %v => %v", keyScaleMetricName, key, valueStr)
-	} else if metricValue, err := parseMetricValue(valueStr); err != nil {
-		return -1, err
-	} else {
-		return metricValue, nil
+func getMetricKey(metadata map[string]string, metricName string) string {
+	metricsPrefix := getEnv(keyMetricsPrefix, defaultMetricsPrefix)
+	metricsKeyTemplate := getEnv(keyMetricsKeyTemplate, defaultMetricsKeyTemplate)
+	deploymentid := getValueFromScalerMetadata(metadata, keyDeploymentId, defaultDeploymentId)
+	return expandKeyTemplate(metricsKeyTemplate, metricsPrefix, deploymentid, metricName)
+}
+
+// aggregateExpressions maps the aggregate metric names to their expressions
+var aggregateExpressions = map[string]string{
+	keyScaleMetricBytesTotal:       keyScaleMetricBytesIn + " + " + keyScaleMetricBytesOut,
+	keyScaleMetricNumRequestsInOut: keyScaleMetricNumRequestsIn + " + " + keyScaleMetricNumRequestsOut,
+	keyScaleMetricNumRequestsTotal: keyScaleMetricNumRequestsIn + " + " + keyScaleMetricNumRequestsOut + " + " + keyScaleMetricNumRequestsMisc,
+}
+
+// getScaleMetricExpression returns the expression of a scale metric:
+// scaleMetricExpression if it is set and the metric is the scaleMetricName,
+// the expression of an aggregate metric, or the metric itself otherwise
+func getScaleMetricExpression(metadata map[string]string, scaleMetricName string) (*metricExpression, error) {
+	scaleMetricExpression := getValueFromScalerMetadata(metadata, keyScaleMetricExpression, "")
+	if scaleMetricExpression != "" {
+		// with scaleMetrics, the metric of the expression must be named
+		// explicitly instead of replacing the default scaleMetricName
+		expressionMetricName := getValueFromScalerMetadata(metadata, keyScaleMetricName, "")
+		if expressionMetricName == "" {
+			if getValueFromScalerMetadata(metadata, keyScaleMetrics, "") != "" {
+				return nil, status.Errorf(codes.InvalidArgument, "%v is required for %v with %v", keyScaleMetricName, keyScaleMetricExpression, keyScaleMetrics)
+			}
+			expressionMetricName = defaultScaleMetricName
+		}
+
+		if scaleMetricName == expressionMetricName {
+			return getMetricExpression(scaleMetricExpression)
+		}
+	}
+
+	if aggregateExpression, exists := aggregateExpressions[strings.ToLower(scaleMetricName)]; exists {
+		return getMetricExpression(aggregateExpression)
 	}
+
+	return newMetricNameExpression(scaleMetricName), nil
 }
 
-func getBytesTotal(metricsPrefix string) (int64, error) {
-	if bytesIn, err := getMetricValue(metricsPrefix, keyScaleMetricBytesIn); err != nil {
-		return -1, err
-	} else if bytesOut, err := getMetricValue(metricsPrefix, keyScaleMetricBytesOut); err != nil {
-		return -1, err
-	} else {
-		bytesTotal := bytesIn + bytesOut
-		return bytesTotal, nil
+func getMetric(ctx context.Context, metadata map[string]string, scaleMetricName string) (metric, error) {
+	log.Debug("getting metric {name, value}")
+
+	source, err := getMetricSource(metadata)
+	if err != nil {
+		return metric{}, err
 	}
+
+	scaleMetricValue, err := source.getMetricValue(ctx, metadata, scaleMetricName)
+	if err != nil {
+		log.Errorf("error while getting metric %v [%v]", scaleMetricName, err.Error())
+		return metric{}, err
+	}
+
+	log.Debugf("returning metric {name: %v, value: %v}", scaleMetricName, scaleMetricValue)
+
+	return metric{scaleMetricName, scaleMetricValue}, nil
 }
 
-func getNumRequestsInOut(metricsPrefix string) (int64, error) {
-	if numRequestsIn, err := getMetricValue(metricsPrefix, keyScaleMetricNumRequestsIn); err != nil {
-		return -1, err
-	} else if numRequestsOut, err := getMetricValue(metricsPrefix, keyScaleMetricNumRequestsOut); err != nil {
+func getMetricAggregation(metadata map[string]string) (string, error) {
+	metricAggregation := getValueFromScalerMetadata(metadata, keyMetricAggregation, defaultMetricAggregation)
+	switch metricAggregation {
+	case metricAggregationDelta, metricAggregationRate, metricAggregationRatePerMinute:
+		return metricAggregation, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyMetricAggregation, metricAggregation)
+	}
+}
+
+func getWindowFunction(metadata map[string]string) (string, error) {
+	windowFunction := getValueFromScalerMetadata(metadata, keyWindowFunction, defaultWindowFunction)
+	switch windowFunction {
+	case windowFunctionDelta, windowFunctionAvgRate, windowFunctionMaxRate, windowFunctionP95Rate, windowFunctionMinRate:
+		return windowFunction, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyWindowFunction, windowFunction)
+	}
+}
+
+func getSmoothing(metadata map[string]string) (string, error) {
+	smoothing := getValueFromScalerMetadata(metadata, keySmoothing, defaultSmoothing)
+	switch smoothing {
+	case smoothingNone, smoothingEwma, smoothingHolt:
+		return smoothing, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keySmoothing, smoothing)
+	}
+}
+
+func getHalfLifeSeconds(metadata map[string]string, key, defaultValue string) (time.Duration, error) {
+	halfLifeSecondsStr := getValueFromScalerMetadata(metadata, key, defaultValue)
+	if halfLifeSeconds, err := parseInt64(halfLifeSecondsStr); err != nil {
 		return -1, err
+	} else if halfLifeSeconds <= 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", key, halfLifeSeconds)
 	} else {
-		numRequestsInOut := numRequestsIn + numRequestsOut
-		return numRequestsInOut, nil
+		return time.Duration(halfLifeSeconds) * time.Second, nil
 	}
 }
 
-func getNumRequestsTotal(metricsPrefix string) (int64, error) {
-	if numRequestsInOut, err := getNumRequestsInOut(metricsPrefix); err != nil {
-		return -1, err
-	} else if numRequestsMisc, err := getMetricValue(metricsPrefix, keyScaleMetricNumRequestsMisc); err != nil {
+func getForecastHorizonSeconds(metadata map[string]string) (int64, error) {
+	forecastHorizonSecondsStr := getValueFromScalerMetadata(metadata, keyForecastHorizonSeconds, defaultForecastHorizonSeconds)
+	if forecastHorizonSeconds, err := parseInt64(forecastHorizonSecondsStr); err != nil {
 		return -1, err
+	} else if forecastHorizonSeconds < 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyForecastHorizonSeconds, forecastHorizonSeconds)
 	} else {
-		numRequestsTotal := numRequestsInOut + numRequestsMisc
-		return numRequestsTotal, nil
+		return forecastHorizonSeconds, nil
 	}
 }
 
-func getMetric(metadata map[string]string) (metric, error) {
-	log.Debug("getting metric {name, value}")
+func getOnRedisError(metadata map[string]string) (string, error) {
+	onRedisError := getValueFromScalerMetadata(metadata, keyOnRedisError, defaultOnRedisError)
+	switch onRedisError {
+	case onRedisErrorError, onRedisErrorLastKnown, onRedisErrorZero, onRedisErrorHold:
+		return onRedisError, nil
+	default:
+		return "", status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyOnRedisError, onRedisError)
+	}
+}
 
-	var scaleMetricValue int64 = 0
-	var err error = nil
+func getMaxStalenessSeconds(metadata map[string]string) (int64, error) {
+	maxStalenessSecondsStr := getValueFromScalerMetadata(metadata, keyMaxStalenessSeconds, defaultMaxStalenessSeconds)
+	if maxStalenessSeconds, err := parseInt64(maxStalenessSecondsStr); err != nil {
+		return -1, err
+	} else if maxStalenessSeconds < 0 {
+		return -1, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyMaxStalenessSeconds, maxStalenessSeconds)
+	} else {
+		return maxStalenessSeconds, nil
+	}
+}
 
-	metricsPrefix := getEnv(keyMetricsPrefix, defaultMetricsPrefix)
-	scaleMetricName := getValueFromScalerMetadata(metadata, keyScaleMetricName, defaultScaleMetricName)
-
-	switch strings.ToLower(scaleMetricName) {
-	case keyScaleMetricBytesTotal:
-		scaleMetricValue, err = getBytesTotal(metricsPrefix)
-	case keyScaleMetricNumRequestsInOut:
-		scaleMetricValue, err = getNumRequestsInOut(metricsPrefix)
-	case keyScaleMetricNumRequestsTotal:
-		scaleMetricValue, err = getNumRequestsTotal(metricsPrefix)
+func getMetricType(metadata map[string]string) (string, error) {
+	metricType := getValueFromScalerMetadata(metadata, keyMetricType, defaultMetricType)
+	switch metricType {
+	case metricTypeCounter, metricTypeZCount, metricTypeZSum, metricTypeLLen, metricTypeXLen, metricTypeXPending:
+		return metricType, nil
 	default:
-		scaleMetricValue, err = getMetricValue(metricsPrefix, scaleMetricName)
+		return "", status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyMetricType, metricType)
 	}
+}
+
+func getTrimEventWindow(metadata map[string]string) (bool, error) {
+	trimEventWindowStr := getValueFromScalerMetadata(metadata, keyTrimEventWindow, defaultTrimEventWindow)
+	if trimEventWindow, err := strconv.ParseBool(trimEventWindowStr); err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "invalid value: %v => %v", keyTrimEventWindow, trimEventWindowStr)
+	} else {
+		return trimEventWindow, nil
+	}
+}
 
+func isQueueMetricType(metricType string) bool {
+	return metricType == metricTypeLLen || metricType == metricTypeXLen || metricType == metricTypeXPending
+}
+
+// hasQueueEntries returns whether any of the queues of the scale metrics has
+// entries, the queues have no last update time
+func hasQueueEntries(ctx context.Context, metadata map[string]string) (bool, error) {
+	scaleMetricNames, err := getScaleMetricNames(metadata)
 	if err != nil {
-		log.Errorf("error while getting metric %v [%v]", scaleMetricName, err.Error())
-		return metric{}, err
+		return false, err
 	}
 
-	log.Debugf("returning metric {name: %v, value: %v}", scaleMetricName, scaleMetricValue)
+	for _, scaleMetricName := range scaleMetricNames {
+		metric, err := getMetric(ctx, metadata, scaleMetricName)
+		if err != nil {
+			return false, err
+		} else if metric.value > 0 {
+			return true, nil
+		}
+	}
 
-	return metric{scaleMetricName, scaleMetricValue}, nil
+	return false, nil
 }
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	webhookStore = newAuditMetricStore()
+)
+
+const (
+	webhookReadHeaderTimeout = 10 * time.Second
+	webhookReadTimeout       = 60 * time.Second
+	webhookWriteTimeout      = 60 * time.Second
+	webhookIdleTimeout       = 120 * time.Second
+
+	webhookExpireInterval = time.Minute // interval for forgetting the idle deployments
+)
+
+// auditEntry is the subset of a MinIO audit log entry used for the metrics
+type auditEntry struct {
+	Time string `json:"time"`
+	API  struct {
+		Name string `json:"name"`
+		Rx   int64  `json:"rx"`
+		Tx   int64  `json:"tx"`
+	} `json:"api"`
+}
+
+// auditMetricStore accumulates the metrics of the MinIO audit log entries per
+// deploymentid, like cwm-worker-logger does in the Redis server
+type auditMetricStore struct {
+	mutex      sync.RWMutex
+	counters   map[string]map[string]int64 // map: deploymentid => metric name => value
+	lastUpdate map[string]time.Time        // map: deploymentid => last action
+	lastSeen   map[string]time.Time        // map: deploymentid => last request, to expire the idle ones
+}
+
+func newAuditMetricStore() *auditMetricStore {
+	return &auditMetricStore{
+		counters:   make(map[string]map[string]int64),
+		lastUpdate: make(map[string]time.Time),
+		lastSeen:   make(map[string]time.Time),
+	}
+}
+
+func getWebhookMaxDeployments() int {
+	maxDeploymentsStr := getEnv(keyWebhookMaxDeployments, defaultWebhookMaxDeployments)
+	maxDeployments, err := strconv.Atoi(maxDeploymentsStr)
+	if err != nil || maxDeployments <= 0 {
+		maxDeployments, _ = strconv.Atoi(defaultWebhookMaxDeployments)
+		log.Warnf("invalid %v: %v. using default: %v", keyWebhookMaxDeployments, maxDeploymentsStr, maxDeployments)
+	}
+
+	return maxDeployments
+}
+
+// getAuditRequestsMetric classifies a MinIO API call into its requests metric
+func getAuditRequestsMetric(apiName string) string {
+	switch apiName {
+	case "PutObject", "DeleteObject", "WebUpload":
+		return keyScaleMetricNumRequestsIn
+	case "GetObject", "WebDownload":
+		return keyScaleMetricNumRequestsOut
+	default:
+		return keyScaleMetricNumRequestsMisc
+	}
+}
+
+func newAuditCounters() map[string]int64 {
+	return map[string]int64{
+		keyScaleMetricBytesIn:         0,
+		keyScaleMetricBytesOut:        0,
+		keyScaleMetricNumRequestsIn:   0,
+		keyScaleMetricNumRequestsOut:  0,
+		keyScaleMetricNumRequestsMisc: 0,
+	}
+}
+
+// reserve admits the entries of a deployment. A new deployment is admitted only
+// while there are less than maxDeployments of them, after forgetting the ones
+// without any entries for idleTimeout.
+func (s *auditMetricStore) reserve(deploymentid string, maxDeployments int, idleTimeout time.Duration) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now().UTC()
+	if _, exists := s.lastSeen[deploymentid]; exists {
+		s.lastSeen[deploymentid] = now
+		return true
+	}
+
+	if len(s.lastSeen) >= maxDeployments {
+		s.expire(now.Add(-idleTimeout))
+		if len(s.lastSeen) >= maxDeployments {
+			return false
+		}
+	}
+
+	s.lastSeen[deploymentid] = now
+	return true
+}
+
+// expireIdle forgets the deployments without any entries for idleTimeout
+func (s *auditMetricStore) expireIdle(idleTimeout time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.expire(time.Now().UTC().Add(-idleTimeout))
+}
+
+// expire forgets the deployments without any entries since the given time,
+// the mutex must be held
+func (s *auditMetricStore) expire(since time.Time) {
+	for deploymentid, lastSeen := range s.lastSeen {
+		if lastSeen.Before(since) {
+			delete(s.counters, deploymentid)
+			delete(s.lastUpdate, deploymentid)
+			delete(s.lastSeen, deploymentid)
+			log.Infof("[deploymentid: %v] audit metrics expired", deploymentid)
+		}
+	}
+}
+
+func (s *auditMetricStore) add(deploymentid string, entry auditEntry) {
+	timestamp, err := time.Parse(time.RFC3339Nano, entry.Time)
+	if err != nil {
+		timestamp = time.Now().UTC()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	counters, exists := s.counters[deploymentid]
+	if !exists {
+		counters = newAuditCounters()
+		s.counters[deploymentid] = counters
+		log.Infof("[deploymentid: %v] audit metrics started", deploymentid)
+	}
+
+	counters[keyScaleMetricBytesIn] += entry.API.Rx
+	counters[keyScaleMetricBytesOut] += entry.API.Tx
+	counters[getAuditRequestsMetric(entry.API.Name)]++
+
+	if timestamp.After(s.lastUpdate[deploymentid]) {
+		s.lastUpdate[deploymentid] = timestamp.UTC()
+	}
+}
+
+func (s *auditMetricStore) getValues(deploymentid string, metricNames []string) (map[string]int64, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	// a deployment without any entries yet e.g. after a restart of the
+	// scaler has zero counters
+	counters, exists := s.counters[deploymentid]
+	if !exists {
+		counters = newAuditCounters()
+	}
+
+	values := make(map[string]int64, len(metricNames))
+	for _, metricName := range metricNames {
+		value, exists := counters[metricName]
+		if !exists {
+			return nil, false
+		}
+		values[metricName] = value
+	}
+
+	return values, true
+}
+
+// getLastUpdateTime returns the zero time for a deployment without any entries
+func (s *auditMetricStore) getLastUpdateTime(deploymentid string) time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.lastUpdate[deploymentid]
+}
+
+// parseAuditEntries parses a request body of a single entry, an array of
+// entries, or newline-delimited entries as sent by MinIO in batches
+func parseAuditEntries(body io.Reader) ([]auditEntry, error) {
+	entries := []auditEntry{}
+	decoder := json.NewDecoder(body)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+			var batch []auditEntry
+			if err := json.Unmarshal(raw, &batch); err != nil {
+				return nil, err
+			}
+			entries = append(entries, batch...)
+		} else {
+			var entry auditEntry
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// handleAuditWebhook serves POST <webhookPath><deploymentid>
+func handleAuditWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if authToken := getEnv(keyWebhookAuthToken, defaultWebhookAuthToken); authToken != "" {
+		expected := "Bearer " + authToken
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	deploymentid := strings.Trim(strings.TrimPrefix(r.URL.Path, webhookPath), "/")
+	if deploymentid == "" || strings.Contains(deploymentid, "/") {
+		http.Error(w, "invalid deploymentid", http.StatusNotFound)
+		return
+	}
+
+	entries, err := parseAuditEntries(http.MaxBytesReader(w, r.Body, webhookMaxBodyBytes))
+	if err != nil {
+		log.Warnf("[deploymentid: %v] invalid audit log entries [%v]", deploymentid, err.Error())
+		http.Error(w, "invalid audit log entries", http.StatusBadRequest)
+		return
+	}
+
+	if len(entries) > 0 {
+		idleTimeout := getDurationSecondsFromEnv(keyWebhookIdleTimeoutSeconds, defaultWebhookIdleTimeoutSeconds)
+		if !webhookStore.reserve(deploymentid, getWebhookMaxDeployments(), idleTimeout) {
+			log.Warnf("[deploymentid: %v] audit log entries rejected, %v reached", deploymentid, keyWebhookMaxDeployments)
+			http.Error(w, "too many deployments", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	for _, entry := range entries {
+		webhookStore.add(deploymentid, entry)
+	}
+
+	log.Debugf("[deploymentid: %v] received %v audit log entries", deploymentid, len(entries))
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func runWebhookServer(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(webhookPath, handleAuditWebhook)
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: webhookReadHeaderTimeout,
+		ReadTimeout:       webhookReadTimeout,
+		WriteTimeout:      webhookWriteTimeout,
+		IdleTimeout:       webhookIdleTimeout,
+	}
+
+	if getEnv(keyWebhookAuthToken, defaultWebhookAuthToken) == "" {
+		log.Warnf("audit webhook accepts unauthenticated entries, %v is not set", keyWebhookAuthToken)
+	}
+
+	go func() {
+		idleTimeout := getDurationSecondsFromEnv(keyWebhookIdleTimeoutSeconds, defaultWebhookIdleTimeoutSeconds)
+		ticker := time.NewTicker(webhookExpireInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			webhookStore.expireIdle(idleTimeout)
+		}
+	}()
+
+	log.Infof("audit webhook server started listening on %v%v{deploymentid}", address, webhookPath)
+
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatalf("audit webhook server failed: %v", err.Error())
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/iamazeem/cwm-keda-external-scaler/externalscaler"
+)
+
+func postAuditEntries(deploymentid, token, body string) int {
+	request := httptest.NewRequest(http.MethodPost, webhookPath+deploymentid, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	handleAuditWebhook(recorder, request)
+
+	return recorder.Code
+}
+
+// useTestWebhookStore swaps in an empty store, the global one is restored when
+// the test is done
+func useTestWebhookStore(tb testing.TB) {
+	store := webhookStore
+	webhookStore = newAuditMetricStore()
+	tb.Cleanup(func() { webhookStore = store })
+}
+
+func TestAuditWebhook(t *testing.T) {
+	setTestEnv(t, map[string]string{keyWebhookAuthToken: "token"})
+	useTestWebhookStore(t)
+
+	entries := `{"time":"2021-03-01T12:00:00Z","api":{"name":"PutObject","rx":100,"tx":1}}
+{"api":{"name":"GetObject","rx":1,"tx":1000}}
+[{"api":{"name":"ListObjectsV2","rx":2,"tx":3}},{"api":{"name":"WebUpload","rx":10}}]`
+
+	if code := postAuditEntries("webhook-test", "", entries); code != http.StatusUnauthorized {
+		t.Errorf("got HTTP %v without a token, want %v", code, http.StatusUnauthorized)
+	}
+	if code := postAuditEntries("webhook-test", "token", entries); code != http.StatusOK {
+		t.Fatalf("got HTTP %v, want %v", code, http.StatusOK)
+	}
+	if code := postAuditEntries("webhook-test", "token", "["+strings.Repeat(" ", webhookMaxBodyBytes)+"]"); code != http.StatusBadRequest {
+		t.Errorf("got HTTP %v for an oversized body, want %v", code, http.StatusBadRequest)
+	}
+
+	want := map[string]int64{
+		keyScaleMetricBytesIn:         113,
+		keyScaleMetricBytesOut:        1004,
+		keyScaleMetricNumRequestsIn:   2,
+		keyScaleMetricNumRequestsOut:  1,
+		keyScaleMetricNumRequestsMisc: 1,
+	}
+	for metricName, value := range want {
+		metadata := map[string]string{keyDeploymentId: "webhook-test"}
+		if got, err := (webhookSource{}).getMetricValue(context.Background(), metadata, metricName); err != nil || got != value {
+			t.Errorf("%v: got %v [%v], want %v", metricName, got, err, value)
+		}
+	}
+}
+
+// TestAuditWebhookUnknownDeployment checks that a deployment without any
+// entries yet e.g. after a restart is inactive with zero counters
+func TestAuditWebhookUnknownDeployment(t *testing.T) {
+	useTestWebhookStore(t)
+
+	ref := &pb.ScaledObjectRef{
+		Name:      "webhook-unknown",
+		Namespace: "webhook-test",
+		ScalerMetadata: map[string]string{
+			keySource:       sourceWebhook,
+			keyDeploymentId: "webhook-unknown",
+		},
+	}
+
+	if active, err := isActive(context.Background(), ref); err != nil || active {
+		t.Errorf("got %v [%v], want inactive", active, err)
+	}
+
+	if m, err := getMetrics(context.Background(), ref, defaultScaleMetricName); err != nil || m.value != 0 {
+		t.Errorf("got %v [%v], want 0", m, err)
+	}
+}
+
+func TestAuditWebhookMaxDeployments(t *testing.T) {
+	setTestEnv(t, map[string]string{
+		keyWebhookAuthToken:          "",
+		keyWebhookMaxDeployments:     "2",
+		keyWebhookIdleTimeoutSeconds: "60",
+	})
+	useTestWebhookStore(t)
+
+	entry := `{"api":{"name":"GetObject","tx":10}}`
+	for _, deploymentid := range []string{"webhook-a", "webhook-b"} {
+		if code := postAuditEntries(deploymentid, "", entry); code != http.StatusOK {
+			t.Fatalf("%v: got HTTP %v, want %v", deploymentid, code, http.StatusOK)
+		}
+	}
+
+	if code := postAuditEntries("webhook-c", "", entry); code != http.StatusServiceUnavailable {
+		t.Errorf("got HTTP %v beyond %v, want %v", code, keyWebhookMaxDeployments, http.StatusServiceUnavailable)
+	}
+	if code := postAuditEntries("webhook-a", "", entry); code != http.StatusOK {
+		t.Errorf("got HTTP %v for a known deployment, want %v", code, http.StatusOK)
+	}
+
+	// an idle deployment is forgotten for a new one
+	webhookStore.mutex.Lock()
+	webhookStore.lastSeen["webhook-b"] = time.Now().UTC().Add(-time.Hour)
+	webhookStore.mutex.Unlock()
+
+	if code := postAuditEntries("webhook-c", "", entry); code != http.StatusOK {
+		t.Errorf("got HTTP %v after an idle deployment, want %v", code, http.StatusOK)
+	}
+	if values, _ := webhookStore.getValues("webhook-b", []string{keyScaleMetricBytesOut}); values[keyScaleMetricBytesOut] != 0 {
+		t.Errorf("got %v for the forgotten deployment, want 0", values[keyScaleMetricBytesOut])
+	}
+	if values, _ := webhookStore.getValues("webhook-a", []string{keyScaleMetricBytesOut}); values[keyScaleMetricBytesOut] != 20 {
+		t.Errorf("got %v, want 20", values[keyScaleMetricBytesOut])
+	}
+
+	webhookStore.expireIdle(0)
+	if len(webhookStore.lastSeen) != 0 || len(webhookStore.counters) != 0 {
+		t.Errorf("got %v deployments after expiring the idle ones, want none", len(webhookStore.lastSeen))
+	}
+}
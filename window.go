@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// getIncrease returns the increase of a counter over the given values (oldest
+// first). A decrease between two consecutive values is treated as a counter
+// reset e.g. after a restart of cwm-worker-logger, and the value after the
+// reset is counted as the increase since the reset (Prometheus-style).
+func getIncrease(values []int64) int64 {
+	var increase int64 = 0
+	for i := 1; i < len(values); i++ {
+		if values[i] >= values[i-1] {
+			increase += values[i] - values[i-1]
+		} else {
+			log.Infof("counter reset detected [%v => %v]", values[i-1], values[i])
+			increase += values[i]
+		}
+	}
+
+	return increase
+}
+
+// getMinRateInterval returns the shortest time a rate is computed over, half
+// of SAMPLE_INTERVAL_SECONDS so that the jitter of the sampler keeps its own
+// intervals. A shorter time e.g. right after the first sample of a series
+// would turn a single burst of a counter into a huge rate.
+func getMinRateInterval() time.Duration {
+	return getDurationSecondsFromEnv(keySampleIntervalSeconds, defaultSampleIntervalSeconds) / 2
+}
+
+// aggregateIncrease normalizes the increase of a counter by the elapsed time
+// between its oldest and newest values according to the metricAggregation.
+func aggregateIncrease(metricAggregation string, increase int64, elapsed, minInterval time.Duration) int64 {
+	var unit time.Duration
+	switch metricAggregation {
+	case metricAggregationRate:
+		unit = time.Second
+	case metricAggregationRatePerMinute:
+		unit = time.Minute
+	default:
+		return increase
+	}
+
+	// not enough time has elapsed between the values, there is no rate to
+	// report yet
+	if elapsed <= 0 || elapsed < minInterval {
+		return 0
+	}
+
+	return int64(math.Round(float64(increase) * float64(unit) / float64(elapsed)))
+}
+
+// getIntervalRates returns the per-second rates between the consecutive
+// metric values (oldest first), counter resets are handled as in getIncrease.
+// An interval shorter than minInterval e.g. right after the first sample of a
+// series is merged with the next one, and dropped if it is the last one e.g.
+// from the newest sample to the value read by GetMetrics.
+func getIntervalRates(data []metricData, minInterval time.Duration) []float64 {
+	rates := make([]float64, 0, len(data))
+	start := 0
+	for i := 1; i < len(data); i++ {
+		elapsed := data[i].timestamp.Sub(data[start].timestamp)
+		if elapsed <= 0 || elapsed < minInterval {
+			continue
+		}
+
+		values := make([]int64, 0, i-start+1)
+		for _, d := range data[start : i+1] {
+			values = append(values, d.metric.value)
+		}
+
+		rates = append(rates, float64(getIncrease(values))/elapsed.Seconds())
+		start = i
+	}
+
+	return rates
+}
+
+// getPercentile returns the p-th percentile (0-100) of the values using
+// linear interpolation between the closest ranks
+func getPercentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+func applyWindowFunction(windowFunction string, rates []float64) float64 {
+	if len(rates) == 0 {
+		return 0
+	}
+
+	switch windowFunction {
+	case windowFunctionAvgRate:
+		sum := 0.0
+		for _, r := range rates {
+			sum += r
+		}
+		return sum / float64(len(rates))
+	case windowFunctionMaxRate:
+		return getPercentile(rates, 100)
+	case windowFunctionMinRate:
+		return getPercentile(rates, 0)
+	case windowFunctionP95Rate:
+		return getPercentile(rates, 95)
+	default:
+		return 0
+	}
+}
+
+// getWindowValue computes the value to report from the metric values within
+// scalePeriodSeconds (oldest first). The delta windowFunction reports the
+// increase over the whole window according to the metricAggregation, the other
+// ones report a statistic of the per-interval rates, per second or per minute
+// with the ratePerMinute metricAggregation.
+func getWindowValue(windowFunction, metricAggregation string, data []metricData, minInterval time.Duration) int64 {
+	if len(data) == 0 {
+		return 0
+	}
+
+	if windowFunction == windowFunctionDelta {
+		values := make([]int64, 0, len(data))
+		for _, d := range data {
+			values = append(values, d.metric.value)
+		}
+
+		increase := getIncrease(values)
+		elapsed := data[len(data)-1].timestamp.Sub(data[0].timestamp)
+		value := aggregateIncrease(metricAggregation, increase, elapsed, minInterval)
+		log.Debugf("window value: %v [increase: %v, elapsed: %v]", value, increase, elapsed)
+		return value
+	}
+
+	rates := getIntervalRates(data, minInterval)
+	rate := applyWindowFunction(windowFunction, rates)
+	if metricAggregation == metricAggregationRatePerMinute {
+		rate *= time.Minute.Seconds()
+	}
+
+	log.Debugf("window value: %v [%v over %v rate(s)]", rate, windowFunction, len(rates))
+
+	return int64(math.Round(rate))
+}
+
+// getReportedValue computes the value to report from the metric values of a
+// series. Only the counters are aggregated over the window, the values of the
+// other metric types (event windows and queue lengths) are already computed by
+// the Redis server and the latest one is reported as is.
+func getReportedValue(metricType, windowFunction, metricAggregation string, data []metricData, minInterval time.Duration) int64 {
+	if metricType == metricTypeCounter {
+		return getWindowValue(windowFunction, metricAggregation, data, minInterval)
+	}
+
+	if len(data) == 0 {
+		return 0
+	}
+
+	return data[len(data)-1].metric.value
+}
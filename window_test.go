@@ -0,0 +1,164 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGetIncrease(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []int64
+		increase int64
+	}{
+		{"empty", []int64{}, 0},
+		{"single value", []int64{100}, 0},
+		{"monotonic", []int64{100, 150, 150, 400}, 300},
+		{"single reset", []int64{100, 150, 30, 80}, 130},
+		{"reset to 0", []int64{100, 150, 0, 20}, 70},
+		{"several resets", []int64{100, 150, 10, 60, 5, 0, 40}, 155},
+	}
+
+	for _, test := range tests {
+		if increase := getIncrease(test.values); increase != test.increase {
+			t.Errorf("%v: got %v, want %v", test.name, increase, test.increase)
+		}
+	}
+}
+
+func TestAggregateIncrease(t *testing.T) {
+	tests := []struct {
+		metricAggregation string
+		increase          int64
+		elapsed           time.Duration
+		value             int64
+	}{
+		{metricAggregationDelta, 600, time.Millisecond, 600},
+		{metricAggregationRate, 600, 60 * time.Second, 10},
+		{metricAggregationRatePerMinute, 600, 60 * time.Second, 600},
+		// a burst right after the first sample of a series is no rate yet
+		{metricAggregationRate, 600, time.Millisecond, 0},
+		{metricAggregationRatePerMinute, 600, 4 * time.Second, 0},
+		{metricAggregationRate, 600, 5 * time.Second, 120},
+		{metricAggregationRate, 600, 0, 0},
+	}
+
+	for _, test := range tests {
+		if value := aggregateIncrease(test.metricAggregation, test.increase, test.elapsed, 5*time.Second); value != test.value {
+			t.Errorf("%v/%v/%v: got %v, want %v", test.metricAggregation, test.increase, test.elapsed, value, test.value)
+		}
+	}
+}
+
+func newTestMetricData(start time.Time, points ...interface{}) []metricData {
+	data := []metricData{}
+	for i := 0; i+1 < len(points); i += 2 {
+		data = append(data, metricData{
+			timestamp: start.Add(points[i].(time.Duration)),
+			metric:    metric{keyScaleMetricBytesOut, int64(points[i+1].(int))},
+		})
+	}
+	return data
+}
+
+func TestGetIntervalRates(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	s := time.Second
+
+	tests := []struct {
+		name  string
+		data  []metricData
+		rates []float64
+	}{
+		{"empty", newTestMetricData(start), []float64{}},
+		{"single value", newTestMetricData(start, 0*s, 100), []float64{}},
+		{"samples", newTestMetricData(start, 0*s, 0, 10*s, 100, 20*s, 300), []float64{10, 20}},
+		{"counter reset", newTestMetricData(start, 0*s, 500, 10*s, 50, 20*s, 150), []float64{5, 10}},
+		// the first sample is taken right before the first interval
+		{"short first interval", newTestMetricData(start, 0*s, 0, s/100, 1000, 10*s, 1100), []float64{110}},
+		// a burst read by GetMetrics right after the newest sample
+		{"short last interval", newTestMetricData(start, 0*s, 0, 10*s, 100, 10*s+s/100, 5000), []float64{10}},
+		// the jitter of the sampler keeps its intervals
+		{"jitter", newTestMetricData(start, 0*s, 0, 9900*time.Millisecond, 99, 20*s, 200), []float64{10, 10}},
+		{"same timestamps", newTestMetricData(start, 0*s, 0, 0*s, 100), []float64{}},
+	}
+
+	for _, test := range tests {
+		rates := getIntervalRates(test.data, 5*time.Second)
+		if len(rates) != len(test.rates) {
+			t.Errorf("%v: got %v, want %v", test.name, rates, test.rates)
+			continue
+		}
+		for i := range rates {
+			if math.Abs(rates[i]-test.rates[i]) > 1e-9 {
+				t.Errorf("%v: got %v, want %v", test.name, rates, test.rates)
+				break
+			}
+		}
+	}
+}
+
+func TestGetPercentile(t *testing.T) {
+	values := []float64{40, 10, 30, 20, 50}
+
+	tests := []struct {
+		values     []float64
+		percentile float64
+		value      float64
+	}{
+		{values, 0, 10},
+		{values, 50, 30},
+		{values, 100, 50},
+		{values, 95, 48},
+		{values, 10, 14},
+		{[]float64{7}, 95, 7},
+		{[]float64{}, 95, 0},
+	}
+
+	for _, test := range tests {
+		if value := getPercentile(test.values, test.percentile); math.Abs(value-test.value) > 1e-9 {
+			t.Errorf("%v/%v: got %v, want %v", test.values, test.percentile, value, test.value)
+		}
+	}
+
+	if values[0] != 40 {
+		t.Errorf("getPercentile sorted the values in place: %v", values)
+	}
+}
+
+func TestApplyWindowFunction(t *testing.T) {
+	rates := []float64{4, 1, 3, 2, 10}
+
+	tests := []struct {
+		windowFunction string
+		rates          []float64
+		value          float64
+	}{
+		{windowFunctionAvgRate, rates, 4},
+		{windowFunctionMaxRate, rates, 10},
+		{windowFunctionMinRate, rates, 1},
+		{windowFunctionP95Rate, rates, 8.8},
+		{windowFunctionMaxRate, []float64{}, 0},
+		{windowFunctionDelta, rates, 0},
+	}
+
+	for _, test := range tests {
+		if value := applyWindowFunction(test.windowFunction, test.rates); math.Abs(value-test.value) > 1e-9 {
+			t.Errorf("%v/%v: got %v, want %v", test.windowFunction, test.rates, value, test.value)
+		}
+	}
+}
+
+// TestGetWindowValueBurst checks that a burst of a counter read by GetMetrics
+// right after a sample does not drive the rates
+func TestGetWindowValueBurst(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	data := newTestMetricData(start, 0*time.Second, 0, 10*time.Second, 100, 20*time.Second, 200, 20*time.Second+time.Millisecond, 1200)
+
+	for _, windowFunction := range []string{windowFunctionMaxRate, windowFunctionP95Rate} {
+		if value := getWindowValue(windowFunction, metricAggregationRate, data, 5*time.Second); value != 10 {
+			t.Errorf("%v: got %v, want 10", windowFunction, value)
+		}
+	}
+}